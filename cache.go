@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/iyacontrol/HyperV-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var cacheAgeDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(collector.Namespace, "exporter", "cache_age_seconds"),
+	"hyperV_exporter: Age of the cached collection result served.",
+	nil,
+	nil,
+)
+
+// cachedCollector serves the last result of the wrapped collector as long as
+// it is younger than maxAge, so that frequent scrapes do not each run the
+// WMI queries. Concurrent scrapes of an expired result share a single
+// collection.
+type cachedCollector struct {
+	collector prometheus.Collector
+	maxAge    time.Duration
+	now       func() time.Time
+
+	mtx     sync.Mutex
+	metrics []prometheus.Metric
+	updated time.Time
+}
+
+func newCachedCollector(c prometheus.Collector, maxAge time.Duration) *cachedCollector {
+	return &cachedCollector{collector: c, maxAge: maxAge, now: time.Now}
+}
+
+// Describe implements prometheus.Collector.
+func (c *cachedCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheAgeDesc
+	c.collector.Describe(ch)
+}
+
+// Collect sends the cached metrics, collecting them first if they are
+// missing or too old.
+func (c *cachedCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mtx.Lock()
+	if c.updated.IsZero() || c.now().Sub(c.updated) > c.maxAge {
+		c.metrics, c.updated = c.collect(), c.now()
+	}
+	metrics, updated := c.metrics, c.updated
+	c.mtx.Unlock()
+
+	for _, m := range metrics {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(cacheAgeDesc, prometheus.GaugeValue, c.now().Sub(updated).Seconds())
+}
+
+func (c *cachedCollector) collect() []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	var metrics []prometheus.Metric
+	go func() {
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		close(done)
+	}()
+	c.collector.Collect(ch)
+	close(ch)
+	<-done
+	return metrics
+}
+
+// refresh replaces the cached metrics. Scrapes keep being served from the
+// previous result while it runs.
+func (c *cachedCollector) refresh() {
+	metrics := c.collect()
+	c.mtx.Lock()
+	c.metrics, c.updated = metrics, c.now()
+	c.mtx.Unlock()
+}
+
+// run refreshes the cache every interval until stop is closed, decoupling
+// the collection from the scrapes. The interval must be shorter than maxAge
+// for scrapes never to wait for a collection.
+func (c *cachedCollector) run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	c.refresh()
+	for {
+		select {
+		case <-ticker.C:
+			c.refresh()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var countDesc = prometheus.NewDesc("test_collections", "Number of collections.", nil, nil)
+
+// countingCollector exposes the number of times it was collected.
+type countingCollector struct {
+	mtx   sync.Mutex
+	count int
+	// block, if set, delays the collections until it is closed.
+	block chan struct{}
+}
+
+func (c *countingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- countDesc
+}
+
+func (c *countingCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.block != nil {
+		<-c.block
+	}
+	c.mtx.Lock()
+	c.count++
+	count := c.count
+	c.mtx.Unlock()
+	ch <- prometheus.MustNewConstMetric(countDesc, prometheus.GaugeValue, float64(count))
+}
+
+// gatherValues returns the values of the metrics of the cached collector.
+func gatherValues(t *testing.T, c *cachedCollector) map[string]float64 {
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]float64{}
+	for _, mf := range mfs {
+		values[mf.GetName()] = mf.Metric[0].GetGauge().GetValue()
+	}
+	return values
+}
+
+func TestCachedCollector(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := newCachedCollector(&countingCollector{}, 30*time.Second)
+	c.now = func() time.Time { return now }
+
+	for _, step := range []struct {
+		advance time.Duration
+		count   float64
+		age     float64
+	}{
+		{0, 1, 0},
+		{10 * time.Second, 1, 10},
+		{20 * time.Second, 1, 30},
+		{time.Second, 2, 0},
+	} {
+		now = now.Add(step.advance)
+		values := gatherValues(t, c)
+		if values["test_collections"] != step.count || values["hyperV_exporter_cache_age_seconds"] != step.age {
+			t.Errorf("after %s: got %v, want %v collections and an age of %v", step.advance, values, step.count, step.age)
+		}
+	}
+}
+
+func TestCachedCollectorSharesCollections(t *testing.T) {
+	inner := &countingCollector{block: make(chan struct{})}
+	c := newCachedCollector(inner, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testutil.CollectAndCount(c)
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(inner.block)
+	wg.Wait()
+
+	if inner.count != 1 {
+		t.Errorf("concurrent scrapes ran %d collections, want 1", inner.count)
+	}
+}
+
+func TestCachedCollectorBackgroundRefresh(t *testing.T) {
+	inner := &countingCollector{}
+	c := newCachedCollector(inner, time.Hour)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.run(5*time.Millisecond, stop)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		inner.mtx.Lock()
+		count := inner.count
+		inner.mtx.Unlock()
+		if count >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d background collections after a second", count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	<-done
+
+	// Scrapes are served from the cache.
+	inner.mtx.Lock()
+	before := inner.count
+	inner.mtx.Unlock()
+	values := gatherValues(t, c)
+	if values["test_collections"] != float64(before) || inner.count != before {
+		t.Errorf("scrape collected again: %v, %d collections before", values, before)
+	}
+}
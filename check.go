@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/iyacontrol/HyperV-exporter/collector"
+)
+
+// problemHints explain the problems reported by the check command.
+var problemHints = map[string]string{
+	"invalid_class":     "the class does not exist on this host: the Hyper-V role or its performance counters are not installed, or the Windows version does not provide it",
+	"invalid_namespace": "the namespace does not exist on this host: the Hyper-V role is probably not installed",
+	"access_denied":     "the account of the exporter may not read the class: run it as LocalSystem or add the account to the Hyper-V Administrators and Performance Monitor Users groups",
+	"connection":        "WMI could not be reached: check that the Windows Management Instrumentation service is running",
+}
+
+// checkCollectors queries the classes the collectors depend on and writes a
+// report to w, with the collectors to disable if some of them cannot work on
+// this host. It returns whether all the classes could be queried.
+func checkCollectors(w io.Writer, collectors map[string]collector.Collector) bool {
+	names := make([]string, 0, len(collectors))
+	for name := range collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "COLLECTOR\tNAMESPACE\tCLASS\tRESULT")
+	var (
+		working  []string
+		failing  []string
+		problems = map[string]bool{}
+	)
+	for _, name := range names {
+		ok := true
+		for _, c := range collector.Check(name, collectors[name]) {
+			result := fmt.Sprintf("ok, %d rows", c.Rows)
+			if c.Err != nil {
+				ok = false
+				problems[c.Problem] = true
+				result = fmt.Sprintf("%s: %s", c.Problem, strings.TrimSpace(c.Err.Error()))
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Collector, c.Namespace, c.Class, result)
+		}
+		if ok {
+			working = append(working, name)
+		} else {
+			failing = append(failing, name)
+		}
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+
+	if len(failing) == 0 {
+		fmt.Fprintln(w, "All collectors can be used on this host.")
+		return true
+	}
+
+	var kinds []string
+	for p := range problems {
+		kinds = append(kinds, p)
+	}
+	sort.Strings(kinds)
+	for _, p := range kinds {
+		if hint, ok := problemHints[p]; ok {
+			fmt.Fprintf(w, "%s: %s.\n\n", p, hint)
+		}
+	}
+	fmt.Fprintf(w, "Failing collectors: %s.", strings.Join(failing, ", "))
+	if len(working) == 0 {
+		fmt.Fprintln(w, " None of the collectors can be used on this host.")
+		return false
+	}
+	fmt.Fprintln(w, " To disable them, run the exporter with:")
+	fmt.Fprintf(w, "  --collectors.enabled=%s\n", strings.Join(working, ","))
+	return false
+}
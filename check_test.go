@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/log"
+)
+
+func TestCheckCollectors(t *testing.T) {
+	config, err := loadConfig("", "", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	collectors, err := loadCollectors(config, []string{"processor", "vid", "vmbus"}, &stubQuerier{}, nil, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if checkCollectors(&buf, collectors) {
+		t.Error("expected the check to fail")
+	}
+	for _, want := range []string{
+		`processor root\cimv2 Win32_PerfRawData_HvStats_HyperVHypervisor ok, 1 rows`,
+		`vid root\cimv2 Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition other: unexpected query`,
+		"Failing collectors: vid, vmbus.",
+		"--collectors.enabled=processor",
+	} {
+		// Ignore the alignment of the table.
+		if !strings.Contains(strings.Join(strings.Fields(buf.String()), " "), want) {
+			t.Errorf("report does not contain %q:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	delete(collectors, "vid")
+	delete(collectors, "vmbus")
+	if !checkCollectors(&buf, collectors) {
+		t.Errorf("expected the check to pass:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/iyacontrol/HyperV-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/log"
+)
+
+// collectFormats are the output formats of the collect command.
+var collectFormats = map[string]expfmt.Format{
+	"text":        expfmt.FmtText,
+	"openmetrics": expfmt.FmtOpenMetrics,
+	"json":        "",
+}
+
+// collectOnce runs the enabled collectors of config once with querier and
+// writes their metrics to w, as they would be served on the metrics path. It
+// returns the errors of the failed collectors, by name.
+func collectOnce(w io.Writer, config *Config, querier collector.Querier, format string) (map[string]string, error) {
+	expFormat, ok := collectFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q, expected text, openmetrics or json", format)
+	}
+	collectors, err := loadCollectors(config, config.Collectors.Enabled, querier, nil, log.Base())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load collectors: %s", err)
+	}
+
+	status := newStatusTracker()
+	reg := prometheus.NewRegistry()
+	wmiCollector := WmiCollector{
+		collectors: collectors,
+		timeout:    time.Duration(config.Collectors.Timeout),
+		status:     status,
+		logger:     log.Base(),
+	}
+	if err := prometheus.WrapRegistererWith(config.Labels.Static, reg).Register(wmiCollector); err != nil {
+		return nil, fmt.Errorf("couldn't register collectors: %s", err)
+	}
+	gatherer, err := newRelabelGatherer(reg, config.Metrics)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load metrics config: %s", err)
+	}
+	mfs, err := gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	failed := map[string]string{}
+	for name, s := range status.check(config.Collectors.Enabled, nil, 0).Collectors {
+		if s.LastError != "" {
+			failed[name] = s.LastError
+		}
+	}
+
+	if format == "json" {
+		return failed, writeJSON(w, mfs, failed)
+	}
+	enc := expfmt.NewEncoder(w, expFormat)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return nil, err
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return failed, nil
+}
+
+// jsonFamily is a metric family in the output of the collect command.
+// Histograms and summaries are flattened into samples like in the text
+// format.
+type jsonFamily struct {
+	Name    string       `json:"name"`
+	Help    string       `json:"help"`
+	Type    string       `json:"type"`
+	Samples []jsonSample `json:"samples"`
+}
+
+type jsonSample struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+	// Value is a string, like in the Prometheus API, so that NaN and the
+	// infinities can be represented.
+	Value string `json:"value"`
+}
+
+func writeJSON(w io.Writer, mfs []*dto.MetricFamily, failed map[string]string) error {
+	out := struct {
+		Metrics []jsonFamily      `json:"metrics"`
+		Errors  map[string]string `json:"errors"`
+	}{Metrics: []jsonFamily{}, Errors: failed}
+
+	for _, mf := range mfs {
+		f := jsonFamily{
+			Name: mf.GetName(),
+			Help: mf.GetHelp(),
+			Type: mf.GetType().String(),
+		}
+		for _, m := range mf.Metric {
+			labels := map[string]string{}
+			for _, l := range m.Label {
+				labels[l.GetName()] = l.GetValue()
+			}
+			add := func(suffix string, value float64, extra ...string) {
+				sampleLabels := labels
+				if len(extra) > 0 {
+					sampleLabels = map[string]string{extra[0]: extra[1]}
+					for k, v := range labels {
+						sampleLabels[k] = v
+					}
+				}
+				f.Samples = append(f.Samples, jsonSample{
+					Name:   f.Name + suffix,
+					Labels: sampleLabels,
+					Value:  formatValue(value),
+				})
+			}
+			switch {
+			case m.Gauge != nil:
+				add("", m.Gauge.GetValue())
+			case m.Counter != nil:
+				add("", m.Counter.GetValue())
+			case m.Untyped != nil:
+				add("", m.Untyped.GetValue())
+			case m.Summary != nil:
+				for _, q := range m.Summary.Quantile {
+					add("", q.GetValue(), "quantile", formatValue(q.GetQuantile()))
+				}
+				add("_sum", m.Summary.GetSampleSum())
+				add("_count", float64(m.Summary.GetSampleCount()))
+			case m.Histogram != nil:
+				for _, b := range m.Histogram.Bucket {
+					add("_bucket", float64(b.GetCumulativeCount()), "le", formatValue(b.GetUpperBound()))
+				}
+				add("_bucket", float64(m.Histogram.GetSampleCount()), "le", "+Inf")
+				add("_sum", m.Histogram.GetSampleSum())
+				add("_count", float64(m.Histogram.GetSampleCount()))
+			}
+		}
+		out.Metrics = append(out.Metrics, f)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// sortedNames returns the keys of m in order.
+func sortedNames(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
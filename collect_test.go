@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCollectOnce(t *testing.T) {
+	config, err := loadConfig("", "", Config{Collectors: CollectorsConfig{Enabled: []string{"processor", "vid"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for format, want := range map[string]string{
+		"text":        "hyperV_processor_logical_processors 8\n",
+		"openmetrics": "hyperV_processor_logical_processors 8.0\n",
+	} {
+		var buf bytes.Buffer
+		failed, err := collectOnce(&buf, config, &stubQuerier{}, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := failed["vid"]; !ok || len(failed) != 1 {
+			t.Errorf("%s: got failed collectors %v, want vid", format, failed)
+		}
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("%s: output does not contain %q:\n%s", format, want, buf.String())
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := collectOnce(&buf, config, &stubQuerier{}, "openmetrics"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(buf.String(), "# EOF\n") {
+		t.Errorf("OpenMetrics output is not terminated:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if _, err := collectOnce(&buf, config, &stubQuerier{}, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Metrics []jsonFamily
+		Errors  map[string]string
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.Errors["vid"], "unexpected query") {
+		t.Errorf("unexpected errors %v", out.Errors)
+	}
+	var found bool
+	for _, f := range out.Metrics {
+		if f.Name == "hyperV_exporter_collector_success" {
+			found = true
+			want := []jsonSample{
+				{Name: f.Name, Labels: map[string]string{"collector": "processor"}, Value: "1"},
+				{Name: f.Name, Labels: map[string]string{"collector": "vid"}, Value: "0"},
+			}
+			if f.Type != "GAUGE" || !reflect.DeepEqual(f.Samples, want) {
+				t.Errorf("unexpected family %+v", f)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("hyperV_exporter_collector_success not found in %+v", out.Metrics)
+	}
+
+	if _, err := collectOnce(&buf, config, &stubQuerier{}, "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
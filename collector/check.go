@@ -0,0 +1,60 @@
+package collector
+
+// ClassCheck is the result of querying a class a collector depends on.
+type ClassCheck struct {
+	Collector string
+	Namespace string
+	Class     string
+	// Rows is the number of rows the query returned.
+	Rows int
+	// Problem is empty when the class could be queried. Otherwise it is the
+	// type of the error, as in the type label of
+	// hyperV_exporter_wmi_query_errors_total, e.g. invalid_class or
+	// access_denied.
+	Problem string
+	Err     error
+}
+
+// classChecker is implemented by the collectors that can check their classes.
+type classChecker interface {
+	checkClasses(name string) []ClassCheck
+}
+
+// Check queries the classes collector c, named name, depends on, to find
+// out whether they exist on the host and can be read. No metrics are
+// collected.
+func Check(name string, c Collector) []ClassCheck {
+	if checker, ok := c.(classChecker); ok {
+		return checker.checkClasses(name)
+	}
+	return nil
+}
+
+func newClassCheck(collector, namespace, class string, rows int, err error) ClassCheck {
+	if namespace == "" {
+		namespace = `root\cimv2`
+	}
+	r := ClassCheck{Collector: collector, Namespace: namespace, Class: class, Rows: rows, Err: err}
+	if err != nil {
+		r.Problem = errorType(err)
+	}
+	return r
+}
+
+func (c *classCollector) checkClasses(name string) []ClassCheck {
+	rows, err := c.query()
+	return []ClassCheck{newClassCheck(name, c.spec.Namespace, c.spec.Class, rows.Len(), err)}
+}
+
+func (c *CustomCollector) checkClasses(name string) []ClassCheck {
+	var checks []ClassCheck
+	for _, class := range c.classes {
+		checks = append(checks, class.checkClasses(name)...)
+	}
+	return checks
+}
+
+func (c *VMLabelsCollector) checkClasses(name string) []ClassCheck {
+	rows, err := c.query()
+	return []ClassCheck{newClassCheck(name, `root\virtualization\v2`, "Msvm_VirtualSystemSettingData", len(rows), err)}
+}
@@ -0,0 +1,25 @@
+package collector
+
+import (
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	collectors, err := NewCollectors([]string{"vid"}, Config{Querier: rowsQuerier{err: notConnectedError("not connected")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := Check("vid", collectors["vid"])
+	if len(checks) != 1 || checks[0].Problem != "connection" || checks[0].Namespace != `root\cimv2` {
+		t.Errorf("unexpected checks %+v", checks)
+	}
+
+	labels, err := NewVMLabelsCollector([]string{"team"}, Config{Querier: rowsQuerier{rows: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks = Check("vm_labels", labels)
+	if len(checks) != 1 || checks[0].Err != nil || checks[0].Rows != 2 || checks[0].Class != "Msvm_VirtualSystemSettingData" {
+		t.Errorf("unexpected checks %+v", checks)
+	}
+}
@@ -0,0 +1,172 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+)
+
+// sFalse is returned by CoInitializeEx when COM is already initialized on
+// the thread.
+const sFalse = 0x00000001
+
+// comThread runs COM calls on a dedicated OS thread with COM initialized, as
+// the objects of WMI must be used from the thread that created them. Calls
+// are run one at a time.
+type comThread struct {
+	mtx    sync.Mutex
+	calls  chan func()
+	closed bool
+}
+
+func newCOMThread() (*comThread, error) {
+	t := &comThread{calls: make(chan func())}
+	initErr := make(chan error)
+	go t.run(initErr)
+	if err := <-initErr; err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *comThread) run(initErr chan<- error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := ole.CoInitializeEx(0, ole.COINIT_MULTITHREADED); err != nil {
+		if oleErr, ok := err.(*ole.OleError); !ok || (oleErr.Code() != ole.S_OK && oleErr.Code() != sFalse) {
+			initErr <- err
+			return
+		}
+	}
+	defer ole.CoUninitialize()
+	close(initErr)
+
+	for call := range t.calls {
+		call()
+	}
+}
+
+// do runs f on the thread and returns its error.
+func (t *comThread) do(f func() error) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.closed {
+		return errors.New("COM thread is closed")
+	}
+	errc := make(chan error, 1)
+	t.calls <- func() { errc <- f() }
+	return <-errc
+}
+
+// close stops the thread once the calls in progress are done.
+func (t *comThread) close() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if !t.closed {
+		t.closed = true
+		close(t.calls)
+	}
+}
+
+// connectServer connects to a WMI namespace with SWbemLocator.ConnectServer
+// and returns the SWbemServices object, to be released by the caller. It
+// must run on a comThread.
+func connectServer(args ...interface{}) (*ole.IDispatch, error) {
+	unknown, err := oleutil.CreateObject("WbemScripting.SWbemLocator")
+	if err != nil {
+		return nil, err
+	}
+	defer unknown.Release()
+	locator, err := unknown.QueryInterface(ole.IID_IDispatch)
+	if err != nil {
+		return nil, err
+	}
+	defer locator.Release()
+
+	services, err := oleutil.CallMethod(locator, "ConnectServer", args...)
+	if err != nil {
+		return nil, err
+	}
+	return services.ToIDispatch(), nil
+}
+
+// execQuery runs query with services and stores the returned objects in dst,
+// a pointer to a slice of structs whose fields are named after the
+// properties to load. It must run on the comThread of services.
+func execQuery(services *ole.IDispatch, query string, dst interface{}) error {
+	rows := reflect.ValueOf(dst)
+	if rows.Kind() != reflect.Ptr || rows.Elem().Kind() != reflect.Slice || rows.Elem().Type().Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("dst must be a pointer to a slice of structs, got %T", dst)
+	}
+	rows = rows.Elem()
+
+	result, err := oleutil.CallMethod(services, "ExecQuery", query)
+	if err != nil {
+		return err
+	}
+	defer result.Clear()
+	enumProperty, err := result.ToIDispatch().GetProperty("_NewEnum")
+	if err != nil {
+		return err
+	}
+	defer enumProperty.Clear()
+	enum, err := enumProperty.ToIUnknown().IEnumVARIANT(ole.IID_IEnumVariant)
+	if err != nil {
+		return err
+	}
+	if enum == nil {
+		return errors.New("ExecQuery returned no enumerator")
+	}
+	defer enum.Release()
+
+	rows.Set(reflect.MakeSlice(rows.Type(), 0, 0))
+	for {
+		item, n, err := enum.Next(1)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		row := reflect.New(rows.Type().Elem()).Elem()
+		err = loadRow(row, item.ToIDispatch())
+		item.Clear()
+		if err != nil {
+			return err
+		}
+		rows.Set(reflect.Append(rows, row))
+	}
+}
+
+// loadRow loads the properties of a WMI object into the exported fields of
+// row.
+func loadRow(row reflect.Value, object *ole.IDispatch) error {
+	for i := 0; i < row.NumField(); i++ {
+		name := row.Type().Field(i).Name
+		if name[0] < 'A' || name[0] > 'Z' {
+			continue
+		}
+		prop, err := oleutil.GetProperty(object, name)
+		if err != nil {
+			return fmt.Errorf("property %s: %s", name, err)
+		}
+		var v interface{}
+		if array := prop.ToArray(); array != nil {
+			v = array.ToValueArray()
+		} else {
+			v = prop.Value()
+		}
+		err = setField(row.Field(i), v)
+		prop.Clear()
+		if err != nil {
+			return fmt.Errorf("property %s: %s", name, err)
+		}
+	}
+	return nil
+}
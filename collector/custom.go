@@ -0,0 +1,200 @@
+package collector
+
+import (
+	"fmt"
+	"reflect"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+)
+
+// CustomQuery declares a user-defined WMI query and the metrics exposed from
+// the rows it returns.
+type CustomQuery struct {
+	// Name is used as the subsystem of the metric names, e.g. the value
+	// "read_bytes" of the query "storage" is exposed as
+	// hyperV_storage_read_bytes.
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+	Class     string `yaml:"class"`
+	// Where is an optional WQL condition, without the WHERE keyword.
+	Where  string        `yaml:"where"`
+	Labels []CustomLabel `yaml:"labels"`
+	Values []CustomValue `yaml:"values"`
+}
+
+// CustomLabel exposes a string property of the class as a label.
+type CustomLabel struct {
+	Field string `yaml:"field"`
+	Name  string `yaml:"name"`
+}
+
+// CustomValue exposes a numeric property of the class as a metric.
+type CustomValue struct {
+	Field string `yaml:"field"`
+	Name  string `yaml:"name"`
+	Help  string `yaml:"help"`
+	// Type is one of gauge (the default), counter or untyped.
+	Type string `yaml:"type"`
+	// Kind is the type of the property: int (the default) for integers,
+	// signed or not, up to 2^63, uint for unsigned 64-bit integers above,
+	// or float for real32 and real64 properties.
+	Kind  string  `yaml:"kind"`
+	Scale float64 `yaml:"scale"`
+}
+
+var customValueTypes = map[string]prometheus.ValueType{
+	"":        prometheus.GaugeValue,
+	"gauge":   prometheus.GaugeValue,
+	"counter": prometheus.CounterValue,
+	"untyped": prometheus.UntypedValue,
+}
+
+var customValueKinds = map[string]reflect.Type{
+	"":      reflect.TypeOf(int64(0)),
+	"int":   reflect.TypeOf(int64(0)),
+	"uint":  reflect.TypeOf(uint64(0)),
+	"float": reflect.TypeOf(float64(0)),
+}
+
+// CustomCollector runs the user-defined queries from the configuration file.
+type CustomCollector struct {
+	classes []*classCollector
+	logger  log.Logger
+}
+
+// NewCustomCollector validates queries and builds a collector running them
+// with the querier of config.
+func NewCustomCollector(queries []CustomQuery, config Config) (Collector, error) {
+	c := &CustomCollector{logger: config.logger().With("collector", "custom")}
+	for i, q := range queries {
+		spec, err := q.classSpec()
+		if err != nil {
+			return nil, fmt.Errorf("custom query %d (%s): %s", i, q.Name, err)
+		}
+		class, err := newClassCollector(spec)
+		if err != nil {
+			return nil, err
+		}
+		class.querier = config.classQuerier(class.spec.Class)
+		class.logger = c.logger.With("custom_query", q.Name).With("class", class.spec.Class)
+		c.classes = append(c.classes, class)
+	}
+	return c, nil
+}
+
+// classSpec checks q and turns it into a classSpec. As there is no Go type
+// for the class, the row struct is built at runtime from the declared
+// fields: strings for labels and the numeric type of their kind for values.
+func (q CustomQuery) classSpec() (classSpec, error) {
+	if q.Name == "" || !model.LabelName(q.Name).IsValid() {
+		return classSpec{}, fmt.Errorf("invalid name %q", q.Name)
+	}
+	if q.Class == "" {
+		return classSpec{}, fmt.Errorf("class is required")
+	}
+	if len(q.Values) == 0 {
+		return classSpec{}, fmt.Errorf("at least one value is required")
+	}
+
+	spec := classSpec{
+		Name:      q.Name,
+		Class:     q.Class,
+		Namespace: q.Namespace,
+		Subsystem: q.Name,
+	}
+	if q.Where != "" {
+		spec.Where = "WHERE " + q.Where
+	}
+
+	var fields []reflect.StructField
+	seen := map[string]bool{}
+	addField := func(name string, typ reflect.Type) error {
+		if !isPropertyName(name) {
+			return fmt.Errorf("invalid field %q, property names must start with an upper case letter", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("field %s is used more than once", name)
+		}
+		seen[name] = true
+		fields = append(fields, reflect.StructField{Name: name, Type: typ})
+		return nil
+	}
+
+	for _, l := range q.Labels {
+		if !model.LabelName(l.Name).IsValid() {
+			return classSpec{}, fmt.Errorf("invalid label name %q", l.Name)
+		}
+		if err := addField(l.Field, reflect.TypeOf("")); err != nil {
+			return classSpec{}, err
+		}
+		spec.Labels = append(spec.Labels, labelSpec{Field: l.Field, Name: l.Name})
+	}
+
+	for _, v := range q.Values {
+		name := prometheus.BuildFQName(Namespace, q.Name, v.Name)
+		if v.Name == "" || !model.IsValidMetricName(model.LabelValue(name)) {
+			return classSpec{}, fmt.Errorf("invalid value name %q", v.Name)
+		}
+		typ, ok := customValueTypes[v.Type]
+		if !ok {
+			return classSpec{}, fmt.Errorf("value %s: unknown type %q", v.Name, v.Type)
+		}
+		kind, ok := customValueKinds[v.Kind]
+		if !ok {
+			return classSpec{}, fmt.Errorf("value %s: unknown kind %q, must be int, uint or float", v.Name, v.Kind)
+		}
+		if err := addField(v.Field, kind); err != nil {
+			return classSpec{}, err
+		}
+		help := v.Help
+		if help == "" {
+			help = q.Class + "." + v.Field
+		}
+		spec.Metrics = append(spec.Metrics, metricSpec{
+			Field: v.Field,
+			Name:  v.Name,
+			Help:  help,
+			Type:  typ,
+			Scale: v.Scale,
+		})
+	}
+
+	spec.Row = reflect.New(reflect.StructOf(fields)).Elem().Interface()
+	return spec, nil
+}
+
+// isPropertyName reports whether name can be used both as a WMI property and
+// as an exported struct field, which the WMI client needs to fill it in.
+func isPropertyName(name string) bool {
+	for i, r := range name {
+		switch {
+		case i == 0 && (r < 'A' || r > 'Z'):
+			return false
+		case r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			return false
+		}
+	}
+	return name != ""
+}
+
+// Describe sends the descriptors of the metrics of every query.
+func (c *CustomCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, class := range c.classes {
+		class.Describe(ch)
+	}
+}
+
+// Collect runs every query. A failing query does not prevent the others from
+// being collected; the last error is returned.
+func (c *CustomCollector) Collect(ch chan<- prometheus.Metric) (err error) {
+	for _, class := range c.classes {
+		if cerr := class.Collect(ch); cerr != nil {
+			class.logger.Errorf("Failed collecting custom query: %s", cerr)
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,144 @@
+package collector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var storageQuery = CustomQuery{
+	Name:  "storage",
+	Class: "Win32_PerfRawData_Counters_HyperVVirtualStorageDevice",
+	Where: "Name LIKE '%vhdx%'",
+	Labels: []CustomLabel{
+		{Field: "Name", Name: "device"},
+	},
+	Values: []CustomValue{
+		{Field: "ReadBytesPersec", Name: "read_bytes_total", Type: "counter"},
+		{Field: "QueueLength", Name: "queue_length", Help: "Current queue length", Scale: 0.5},
+	},
+}
+
+func TestCustomQuerySpec(t *testing.T) {
+	c, err := NewCustomCollector([]CustomQuery{storageQuery}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	class := c.(*CustomCollector).classes[0]
+
+	if q := createQuery(reflect.New(reflect.SliceOf(class.row)).Interface(), class.spec.Class, class.spec.Where); q != "SELECT Name, ReadBytesPersec, QueueLength FROM Win32_PerfRawData_Counters_HyperVVirtualStorageDevice WHERE Name LIKE '%vhdx%'" {
+		t.Errorf("unexpected query %q", q)
+	}
+
+	rows := reflect.MakeSlice(reflect.SliceOf(class.row), 1, 1)
+	rows.Index(0).FieldByName("Name").SetString("vm01.vhdx")
+	rows.Index(0).FieldByName("ReadBytesPersec").SetInt(1024)
+	rows.Index(0).FieldByName("QueueLength").SetInt(3)
+
+	want := `
+# HELP hyperV_storage_queue_length Current queue length
+# TYPE hyperV_storage_queue_length gauge
+hyperV_storage_queue_length{device="vm01.vhdx"} 1.5
+# HELP hyperV_storage_read_bytes_total Win32_PerfRawData_Counters_HyperVVirtualStorageDevice.ReadBytesPersec
+# TYPE hyperV_storage_read_bytes_total counter
+hyperV_storage_read_bytes_total{device="vm01.vhdx"} 1024
+`
+	if err := testutil.CollectAndCompare(rowsCollector{class, rows}, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCustomQueryValueKinds(t *testing.T) {
+	q := CustomQuery{
+		Name:  "sensor",
+		Class: "Win32_Sensor",
+		Values: []CustomValue{
+			{Field: "Offset", Name: "offset"},
+			{Field: "Ratio", Name: "ratio", Kind: "float"},
+			{Field: "Bytes", Name: "bytes_total", Kind: "uint", Type: "counter"},
+		},
+	}
+	c, err := NewCustomCollector([]CustomQuery{q}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	class := c.(*CustomCollector).classes[0]
+
+	rows := reflect.MakeSlice(reflect.SliceOf(class.row), 1, 1)
+	for field, value := range map[string]interface{}{
+		"Offset": int32(-1),
+		"Ratio":  float32(0.25),
+		"Bytes":  "9223372036854775808",
+	} {
+		if err := setField(rows.Index(0).FieldByName(field), value); err != nil {
+			t.Fatalf("%s: %s", field, err)
+		}
+	}
+
+	want := `
+# HELP hyperV_sensor_bytes_total Win32_Sensor.Bytes
+# TYPE hyperV_sensor_bytes_total counter
+hyperV_sensor_bytes_total 9.223372036854776e+18
+# HELP hyperV_sensor_offset Win32_Sensor.Offset
+# TYPE hyperV_sensor_offset gauge
+hyperV_sensor_offset -1
+# HELP hyperV_sensor_ratio Win32_Sensor.Ratio
+# TYPE hyperV_sensor_ratio gauge
+hyperV_sensor_ratio 0.25
+`
+	if err := testutil.CollectAndCompare(rowsCollector{class, rows}, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCustomQueryKeepsTotalRows(t *testing.T) {
+	c, err := NewCustomCollector([]CustomQuery{storageQuery}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	class := c.(*CustomCollector).classes[0]
+
+	names := []string{"_Total", "Disk_Total_backup"}
+	rows := reflect.MakeSlice(reflect.SliceOf(class.row), len(names), len(names))
+	for i, name := range names {
+		rows.Index(i).FieldByName("Name").SetString(name)
+	}
+	if got := testutil.CollectAndCount(rowsCollector{class, rows}, "hyperV_storage_queue_length"); got != len(names) {
+		t.Errorf("got %d series, want one per row", got)
+	}
+}
+
+// rowsCollector exposes fixed rows through a classCollector.
+type rowsCollector struct {
+	class *classCollector
+	rows  reflect.Value
+}
+
+func (r rowsCollector) Describe(ch chan<- *prometheus.Desc) { r.class.Describe(ch) }
+func (r rowsCollector) Collect(ch chan<- prometheus.Metric) { r.class.collectRows(ch, r.rows) }
+
+func TestCustomQueryValidation(t *testing.T) {
+	for name, mutate := range map[string]func(q *CustomQuery){
+		"missing name":       func(q *CustomQuery) { q.Name = "" },
+		"invalid name":       func(q *CustomQuery) { q.Name = "hyper-v" },
+		"missing class":      func(q *CustomQuery) { q.Class = "" },
+		"no values":          func(q *CustomQuery) { q.Values = nil },
+		"invalid label":      func(q *CustomQuery) { q.Labels[0].Name = "0device" },
+		"lower case field":   func(q *CustomQuery) { q.Values[0].Field = "readBytes" },
+		"field used twice":   func(q *CustomQuery) { q.Values[1].Field = "Name" },
+		"unknown type":       func(q *CustomQuery) { q.Values[0].Type = "histogram" },
+		"unknown kind":       func(q *CustomQuery) { q.Values[0].Kind = "string" },
+		"missing value name": func(q *CustomQuery) { q.Values[0].Name = "" },
+	} {
+		q := storageQuery
+		q.Labels = append([]CustomLabel{}, storageQuery.Labels...)
+		q.Values = append([]CustomValue{}, storageQuery.Values...)
+		mutate(&q)
+		if _, err := NewCustomCollector([]CustomQuery{q}, Config{}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package collector
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/expfmt"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// fixtureNames are the instance names of the fixture rows, by class, in the
+// format of the host.
+var fixtureNames = map[string]string{
+	"Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition":            "vm01",
+	"Win32_PerfRawData_VmbusStats_HyperVVirtualMachineBus":              "vm01",
+	"Win32_PerfRawData_VmbusStats_HyperVVirtualMachineBusPipes":         "vm01:pipe0",
+	"Win32_PerfRawData_HvStats_HyperVHypervisorRootPartition":           "Root",
+	"Win32_PerfRawData_HvStats_HyperVHypervisorPartition":               "vm01:HvPt",
+	"Win32_PerfRawData_HvStats_HyperVHypervisorRootVirtualProcessor":    "Root VP 3",
+	"Win32_PerfRawData_NvspSwitchStats_HyperVVirtualSwitch":             "Default Switch",
+	"Win32_PerfRawData_EthernetPerfProvider_HyperVLegacyNetworkAdapter": "vm01_Legacy Network Adapter",
+}
+
+// fixtureQuerier returns a row of the class queried, with every counter set
+// to a different value so that swapped fields show, followed by a _Total row
+// when the class has instances.
+type fixtureQuerier struct{}
+
+func (fixtureQuerier) Query(query string, dst interface{}, namespace string) error {
+	rows := reflect.ValueOf(dst).Elem()
+	rowType := rows.Type().Elem()
+
+	row := reflect.New(rowType).Elem()
+	for i := 0; i < row.NumField(); i++ {
+		switch f := row.Field(i); f.Kind() {
+		case reflect.Uint32, reflect.Uint64:
+			f.SetUint(uint64(i + 1))
+		}
+	}
+	name := row.FieldByName("Name")
+	if name.IsValid() {
+		name.SetString(fixtureNames[rowType.Name()])
+	}
+	rows.Set(reflect.Append(reflect.MakeSlice(rows.Type(), 0, 2), row))
+
+	if name.IsValid() {
+		total := reflect.New(rowType).Elem()
+		total.FieldByName("Name").SetString("_Total")
+		rows.Set(reflect.Append(rows, total))
+	}
+	return nil
+}
+
+// registered adapts a Collector to prometheus.Collector, failing the test if
+// the collection fails.
+type registered struct {
+	Collector
+	t *testing.T
+}
+
+func (r registered) Collect(ch chan<- prometheus.Metric) {
+	if err := r.Collector.Collect(ch); err != nil {
+		r.t.Error(err)
+	}
+}
+
+// gather returns the text exposition of the collector.
+func gather(t *testing.T, c Collector) []byte {
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(registered{c, t}); err != nil {
+		t.Fatal(err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for _, mf := range families {
+		if _, err := expfmt.MetricFamilyToText(&buf, mf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// TestGolden compares the output of the collectors to testdata/<config>/<name>.prom.
+// Run with -update after changing the collectors to regenerate the files.
+func TestGolden(t *testing.T) {
+	for cname, names := range map[string][]string{
+		"default": Available(),
+		// Legacy names only add metrics to the root partition.
+		"legacy": {"hv"},
+	} {
+		config := configs[cname]
+		config.Querier = fixtureQuerier{}
+		collectors, err := NewCollectors(names, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, c := range collectors {
+			got := gather(t, c)
+			path := filepath.Join("testdata", cname, name+".prom")
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(path, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s: %s: output differs from %s:\n%s", cname, name, path, got)
+			}
+		}
+	}
+}
+
+// camelCase is the problem the linter reports for the hyperV namespace, which
+// predates it.
+const camelCase = "metric names should be written in 'snake_case' not 'camelCase'"
+
+// lintExceptions are the problems of published metric names, kept as they
+// are not to break the dashboards using them. New metrics must pass the linter.
+var lintExceptions = map[string]bool{
+	"hyperV_ethernet_bytes_persec: " + camelCase:                                    true,
+	"hyperV_ethernet_bytes_received_persec: " + camelCase:                           true,
+	"hyperV_ethernet_bytes_sent_persec: " + camelCase:                               true,
+	"hyperV_ethernet_frames_dropped: " + camelCase:                                  true,
+	"hyperV_ethernet_frames_received_persec: " + camelCase:                          true,
+	"hyperV_ethernet_frames_sent_persec: " + camelCase:                              true,
+	"hyperV_health_health_critical: " + camelCase:                                   true,
+	"hyperV_health_health_ok: " + camelCase:                                         true,
+	"hyperV_hv_1G_device_pages: " + camelCase:                                       true,
+	"hyperV_hv_1G_gpa_pages: " + camelCase:                                          true,
+	"hyperV_hv_2M_device_pages: " + camelCase:                                       true,
+	"hyperV_hv_2M_gpa_pages: " + camelCase:                                          true,
+	"hyperV_hv_4K_device_pages: " + camelCase:                                       true,
+	"hyperV_hv_4K_gpa_pages: " + camelCase:                                          true,
+	"hyperV_hv_address_spaces: " + camelCase:                                        true,
+	"hyperV_hv_attached_devices: " + camelCase:                                      true,
+	"hyperV_hv_deposited_pages: " + camelCase:                                       true,
+	"hyperV_hv_device_dma_errors: " + camelCase:                                     true,
+	"hyperV_hv_device_interrupt_errors: " + camelCase:                               true,
+	"hyperV_hv_device_interrupt_mappings: " + camelCase:                             true,
+	"hyperV_hv_device_interrupt_throttle_events: " + camelCase:                      true,
+	"hyperV_hv_gpa_pages: " + camelCase:                                             true,
+	"hyperV_hv_gpa_space_modifications_persec: " + camelCase:                        true,
+	"hyperV_hv_io_tlb_flush_cost: " + camelCase:                                     true,
+	"hyperV_hv_io_tlb_flush_persec: " + camelCase:                                   true,
+	"hyperV_hv_partition_1G_device_pages: " + camelCase:                             true,
+	"hyperV_hv_partition_1G_gpa_pages: " + camelCase:                                true,
+	"hyperV_hv_partition_2M_device_pages: " + camelCase:                             true,
+	"hyperV_hv_partition_2M_gpa_pages: " + camelCase:                                true,
+	"hyperV_hv_partition_4K_device_pages: " + camelCase:                             true,
+	"hyperV_hv_partition_4K_gpa_pages: " + camelCase:                                true,
+	"hyperV_hv_partition_address_spaces: " + camelCase:                              true,
+	"hyperV_hv_partition_attached_devices: " + camelCase:                            true,
+	"hyperV_hv_partition_deposited_pages: " + camelCase:                             true,
+	"hyperV_hv_partition_device_dma_errors: " + camelCase:                           true,
+	"hyperV_hv_partition_device_interrupt_errors: " + camelCase:                     true,
+	"hyperV_hv_partition_device_interrupt_mappings: " + camelCase:                   true,
+	"hyperV_hv_partition_device_interrupt_throttle_events: " + camelCase:            true,
+	"hyperV_hv_partition_gpa_pages: " + camelCase:                                   true,
+	"hyperV_hv_partition_gpa_space_modifications_persec: " + camelCase:              true,
+	"hyperV_hv_partition_io_tlb_flush_cost: " + camelCase:                           true,
+	"hyperV_hv_partition_io_tlb_flush_persec: " + camelCase:                         true,
+	"hyperV_hv_partition_recommended_virtual_tlb_size: " + camelCase:                true,
+	"hyperV_hv_partition_skipped_timer_ticks: " + camelCase:                         true,
+	"hyperV_hv_partition_virtual_tlb_flush_entires_persec: " + camelCase:            true,
+	"hyperV_hv_partition_virtual_tlb_pages: " + camelCase:                           true,
+	"hyperV_hv_physical_pages_allocated: " + camelCase:                              true,
+	"hyperV_hv_preferred_numa_node_index: " + camelCase:                             true,
+	"hyperV_hv_recommended_virtual_tlb_size: " + camelCase:                          true,
+	"hyperV_hv_skipped_timer_ticks: " + camelCase:                                   true,
+	"hyperV_hv_virtual_tlb_flush_entires_persec: " + camelCase:                      true,
+	"hyperV_hv_virtual_tlb_pages: " + camelCase:                                     true,
+	"hyperV_processor_logical_processors: " + camelCase:                             true,
+	"hyperV_processor_virtual_processors: " + camelCase:                             true,
+	"hyperV_rate_guest_run_time: " + camelCase:                                      true,
+	"hyperV_rate_hypervisor_run_time: " + camelCase:                                 true,
+	"hyperV_rate_remote_run_time: " + camelCase:                                     true,
+	"hyperV_rate_total_run_time: " + camelCase:                                      true,
+	"hyperV_switch_broadcast_packets_received_total_persec: " + camelCase:           true,
+	"hyperV_switch_broadcast_packets_sent_total_persec: " + camelCase:               true,
+	"hyperV_switch_bytes_received_total_persec: " + camelCase:                       true,
+	"hyperV_switch_bytes_sent_total_persec: " + camelCase:                           true,
+	"hyperV_switch_bytes_total_persec: " + camelCase:                                true,
+	"hyperV_switch_directed_packets_received_total_persec: " + camelCase:            true,
+	"hyperV_switch_directed_packets_send_total_persec: " + camelCase:                true,
+	"hyperV_switch_dropped_packets_incoming_total_persec: " + camelCase:             true,
+	"hyperV_switch_dropped_packets_outcoming_total_persec: " + camelCase:            true,
+	"hyperV_switch_extensions_dropped_packets_incoming_total_persec: " + camelCase:  true,
+	"hyperV_switch_extensions_dropped_packets_outcoming_total_persec: " + camelCase: true,
+	"hyperV_switch_learned_mac_addresses: " + camelCase:                             true,
+	"hyperV_switch_learned_mac_addresses_total_persec: " + camelCase:                true,
+	"hyperV_switch_multicast_packets_received_total_persec: " + camelCase:           true,
+	"hyperV_switch_multicast_packets_sent_total_persec: " + camelCase:               true,
+	"hyperV_switch_number_of_send_channel_moves_total_persec: " + camelCase:         true,
+	"hyperV_switch_number_of_vmq_moves_total_persec: " + camelCase:                  true,
+	"hyperV_switch_packets_flooded: " + camelCase:                                   true,
+	"hyperV_switch_packets_flooded_total_persec: " + camelCase:                      true,
+	"hyperV_switch_packets_received_total_persec: " + camelCase:                     true,
+	"hyperV_switch_packets_sent_total_persec: " + camelCase:                         true,
+	"hyperV_switch_packets_total_persec: " + camelCase:                              true,
+	"hyperV_switch_purged_mac_addresses: " + camelCase:                              true,
+	"hyperV_switch_purged_mac_addresses_total_persec: " + camelCase:                 true,
+	"hyperV_vid_physical_pages_allocated: " + camelCase:                             true,
+	"hyperV_vid_preferred_numa_node_index: " + camelCase:                            true,
+	"hyperV_vid_remote_physical_pages: " + camelCase:                                true,
+	"hyperV_vmbus_interrupts_received_persec: " + camelCase:                         true,
+	"hyperV_vmbus_interrupts_sent_persec: " + camelCase:                             true,
+	"hyperV_vmbus_pipe_bytes_read_persec: " + camelCase:                             true,
+	"hyperV_vmbus_pipe_bytes_written_persec: " + camelCase:                          true,
+	"hyperV_vmbus_pipe_reads_persec: " + camelCase:                                  true,
+	"hyperV_vmbus_pipe_writes_persec: " + camelCase:                                 true,
+	"hyperV_vmbus_throttle_events_total: " + camelCase:                              true,
+
+	`hyperV_switch_learned_mac_addresses: counter metrics should have "_total" suffix`: true,
+	`hyperV_switch_packets_flooded: counter metrics should have "_total" suffix`:       true,
+	`hyperV_switch_purged_mac_addresses: counter metrics should have "_total" suffix`:  true,
+}
+
+func TestLint(t *testing.T) {
+	for cname, config := range configs {
+		config.Querier = fixtureQuerier{}
+		collectors, err := NewCollectors(Available(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, c := range collectors {
+			problems, err := testutil.CollectAndLint(registered{c, t})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range problems {
+				if problem := p.Metric + ": " + p.Text; !lintExceptions[problem] {
+					t.Errorf("%s: %s: %s", cname, name, problem)
+				}
+			}
+		}
+	}
+}
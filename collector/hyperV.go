@@ -1,525 +1,112 @@
 package collector
 
 import (
-	"log"
+	"fmt"
+	"regexp"
 	"strings"
 
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
 )
 
-// HyperVCollector is a Prometheus collector for hyper-v
-type HyperVCollector struct {
-	// Win32_PerfRawData_VmmsVirtualMachineStats_HyperVVirtualMachineHealthSummary
-	HealthCritical *prometheus.Desc
-	HealthOk       *prometheus.Desc
-
-	// Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition
-	PhysicalPagesAllocated *prometheus.Desc
-	PreferredNUMANodeIndex *prometheus.Desc
-	RemotePhysicalPages    *prometheus.Desc
-
-	// Win32_PerfRawData_HvStats_HyperVHypervisorRootPartition
-	AddressSpaces                 *prometheus.Desc
-	AttachedDevices               *prometheus.Desc
-	DepositedPages                *prometheus.Desc
-	DeviceDMAErrors               *prometheus.Desc
-	DeviceInterruptErrors         *prometheus.Desc
-	DeviceInterruptMappings       *prometheus.Desc
-	DeviceInterruptThrottleEvents *prometheus.Desc
-	GPAPages                      *prometheus.Desc
-	GPASpaceModificationsPersec   *prometheus.Desc
-	IOTLBFlushCost                *prometheus.Desc
-	IOTLBFlushesPersec            *prometheus.Desc
-	RecommendedVirtualTLBSize     *prometheus.Desc
-	SkippedTimerTicks             *prometheus.Desc
-	Value1Gdevicepages            *prometheus.Desc
-	Value1GGPApages               *prometheus.Desc
-	Value2Mdevicepages            *prometheus.Desc
-	Value2MGPApages               *prometheus.Desc
-	Value4Kdevicepages            *prometheus.Desc
-	Value4KGPApages               *prometheus.Desc
-	VirtualTLBFlushEntiresPersec  *prometheus.Desc
-	VirtualTLBPages               *prometheus.Desc
-
-	// Win32_PerfRawData_HvStats_HyperVHypervisor
-	LogicalProcessors *prometheus.Desc
-	VirtualProcessors *prometheus.Desc
-
-	// Win32_PerfRawData_HvStats_HyperVHypervisorVirtualProcessor
-	PercentGuestRunTime      *prometheus.Desc
-	PercentHypervisorRunTime *prometheus.Desc
-	PercentRemoteRunTime     *prometheus.Desc
-	PercentTotalRunTime      *prometheus.Desc
-
-	// Win32_PerfRawData_NvspSwitchStats_HyperVVirtualSwitch
-	BroadcastPacketsReceivedPersec         *prometheus.Desc
-	BroadcastPacketsSentPersec             *prometheus.Desc
-	BytesPersec                            *prometheus.Desc
-	BytesReceivedPersec                    *prometheus.Desc
-	BytesSentPersec                        *prometheus.Desc
-	DirectedPacketsReceivedPersec          *prometheus.Desc
-	DirectedPacketsSentPersec              *prometheus.Desc
-	DroppedPacketsIncomingPersec           *prometheus.Desc
-	DroppedPacketsOutgoingPersec           *prometheus.Desc
-	ExtensionsDroppedPacketsIncomingPersec *prometheus.Desc
-	ExtensionsDroppedPacketsOutgoingPersec *prometheus.Desc
-	LearnedMacAddresses                    *prometheus.Desc
-	LearnedMacAddressesPersec              *prometheus.Desc
-	MulticastPacketsReceivedPersec         *prometheus.Desc
-	MulticastPacketsSentPersec             *prometheus.Desc
-	NumberofSendChannelMovesPersec         *prometheus.Desc
-	NumberofVMQMovesPersec                 *prometheus.Desc
-	PacketsFlooded                         *prometheus.Desc
-	PacketsFloodedPersec                   *prometheus.Desc
-	PacketsPersec                          *prometheus.Desc
-	PacketsReceivedPersec                  *prometheus.Desc
-	PacketsSentPersec                      *prometheus.Desc
-	PurgedMacAddresses                     *prometheus.Desc
-	PurgedMacAddressesPersec               *prometheus.Desc
-
-	// Win32_PerfRawData_EthernetPerfProvider_HyperVLegacyNetworkAdapter
-	AdapterBytesDropped         *prometheus.Desc
-	AdapterBytesReceivedPersec  *prometheus.Desc
-	AdapterBytesSentPersec      *prometheus.Desc
-	AdapterFramesDropped        *prometheus.Desc
-	AdapterFramesReceivedPersec *prometheus.Desc
-	AdapterFramesSentPersec     *prometheus.Desc
+// Config holds the options of the hyper-v collectors.
+type Config struct {
+	// LegacyMetricNames keeps exposing the root partition metrics that were
+	// renamed to fix name collisions under their old names as well. It will
+	// be removed in the next release.
+	LegacyMetricNames bool
+	// VMInclude, if set, restricts the per-VM series to the VMs whose name
+	// matches it. VMExclude drops the VMs whose name matches it. Both are
+	// matched with regexp.MatchString; the exporter anchors them.
+	VMInclude *regexp.Regexp
+	VMExclude *regexp.Regexp
+	// Querier runs the queries of the collectors. It defaults to WMIQuerier
+	// on the local host.
+	Querier Querier
+	// Logger receives the logs of the collectors, with the collector and
+	// the class they concern as fields. It defaults to log.Base().
+	Logger log.Logger
+	// Metrics, if set, records the queries of the collectors.
+	Metrics *QueryMetrics
 }
 
-// NewHyperVCollector ...
-func NewHyperVCollector() (Collector, error) {
-	return &HyperVCollector{
-		HealthCritical: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "health", "health_critical"),
-			"This counter represents the number of virtual machines with critical health",
-			nil,
-			nil,
-		),
-		HealthOk: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "health", "health_ok"),
-			"This counter represents the number of virtual machines with ok health",
-			nil,
-			nil,
-		),
-
-		//
-
-		PhysicalPagesAllocated: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "vid", "physical_pages_allocated"),
-			"The number of physical pages allocated",
-			nil,
-			nil,
-		),
-		PreferredNUMANodeIndex: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "vid", "preferred_numa_node_index"),
-			"The preferred NUMA node index associated with this partition",
-			nil,
-			nil,
-		),
-		RemotePhysicalPages: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "vid", "remote_physical_pages"),
-			"The number of physical pages not allocated from the preferred NUMA node",
-			nil,
-			nil,
-		),
-
-		//
-
-		AddressSpaces: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "address_spaces"),
-			"The number of address spaces in the virtual TLB of the partition",
-			nil,
-			nil,
-		),
-		AttachedDevices: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "attached_devices"),
-			"The number of devices attached to the partition",
-			nil,
-			nil,
-		),
-		DepositedPages: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "deposited_pages"),
-			"The number of pages deposited into the partition",
-			nil,
-			nil,
-		),
-		DeviceDMAErrors: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "device_dma_errors"),
-			"An indicator of illegal DMA requests generated by all devices assigned to the partition",
-			nil,
-			nil,
-		),
-		DeviceInterruptErrors: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "device_interrupt_errors"),
-			"An indicator of illegal interrupt requests generated by all devices assigned to the partition",
-			nil,
-			nil,
-		),
-		DeviceInterruptMappings: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "device_interrupt_mappings"),
-			"The number of device interrupt mappings used by the partition",
-			nil,
-			nil,
-		),
-		DeviceInterruptThrottleEvents: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "device_interrupt_throttle_events"),
-			"The number of times an interrupt from a device assigned to the partition was temporarily throttled because the device was generating too many interrupts",
-			nil,
-			nil,
-		),
-		GPAPages: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "preferred_numa_node_index"),
-			"The number of pages present in the GPA space of the partition (zero for root partition)",
-			nil,
-			nil,
-		),
-		GPASpaceModificationsPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "gpa_space_modifications_persec"),
-			"The rate of modifications to the GPA space of the partition",
-			nil,
-			nil,
-		),
-		IOTLBFlushCost: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "io_tlb_flush_cost"),
-			"The average time (in nanoseconds) spent processing an I/O TLB flush",
-			nil,
-			nil,
-		),
-		IOTLBFlushesPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "io_tlb_flush_persec"),
-			"The rate of flushes of I/O TLBs of the partition",
-			nil,
-			nil,
-		),
-		RecommendedVirtualTLBSize: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "recommended_virtual_tlb_size"),
-			"The recommended number of pages to be deposited for the virtual TLB",
-			nil,
-			nil,
-		),
-		SkippedTimerTicks: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "physical_pages_allocated"),
-			"The number of timer interrupts skipped for the partition",
-			nil,
-			nil,
-		),
-		Value1Gdevicepages: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "1G_device_pages"),
-			"The number of 1G pages present in the device space of the partition",
-			nil,
-			nil,
-		),
-		Value1GGPApages: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "1G_gpa_pages"),
-			"The number of 1G pages present in the GPA space of the partition",
-			nil,
-			nil,
-		),
-		Value2Mdevicepages: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "2M_device_pages"),
-			"The number of 2M pages present in the device space of the partition",
-			nil,
-			nil,
-		),
-		Value2MGPApages: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "2M_gpa_pages"),
-			"The number of 2M pages present in the GPA space of the partition",
-			nil,
-			nil,
-		),
-		Value4Kdevicepages: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "4K_device_pages"),
-			"The number of 4K pages present in the device space of the partition",
-			nil,
-			nil,
-		),
-		Value4KGPApages: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "4K_gpa_pages"),
-			"The number of 4K pages present in the GPA space of the partition",
-			nil,
-			nil,
-		),
-		VirtualTLBFlushEntiresPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "virtual_tlb_flush_entires_persec"),
-			"The rate of flushes of the entire virtual TLB",
-			nil,
-			nil,
-		),
-		VirtualTLBPages: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "hv", "virtual_tlb_pages"),
-			"The number of pages used by the virtual TLB of the partition",
-			nil,
-			nil,
-		),
-
-		//
-
-		VirtualProcessors: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "processor", "virtual_processors"),
-			"The number of virtual processors present in the system",
-			nil,
-			nil,
-		),
-		LogicalProcessors: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "processor", "logical_processors"),
-			"The number of logical processors present in the system",
-			nil,
-			nil,
-		),
-
-		//
-
-		PercentGuestRunTime: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "rate", "guest_run_time"),
-			"The percentage of time spent by the virtual processor in guest code",
-			[]string{"core"},
-			nil,
-		),
-		PercentHypervisorRunTime: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "rate", "hypervisor_run_time"),
-			"The percentage of time spent by the virtual processor in hypervisor code",
-			[]string{"core"},
-			nil,
-		),
-		PercentRemoteRunTime: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "rate", "remote_run_time"),
-			"The percentage of time spent by the virtual processor running on a remote node",
-			[]string{"core"},
-			nil,
-		),
-		PercentTotalRunTime: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "rate", "total_run_time"),
-			"The percentage of time spent by the virtual processor in guest and hypervisor code",
-			[]string{"core"},
-			nil,
-		),
-
-		//
-		BroadcastPacketsReceivedPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "broadcast_packets_received_total_persec"),
-			"This represents the total number of broadcast packets received per second by the virtual switch",
-			nil,
-			nil,
-		),
-		BroadcastPacketsSentPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "broadcast_packets_sent_total_persec"),
-			"This represents the total number of broadcast packets sent per second by the virtual switch",
-			nil,
-			nil,
-		),
-		BytesPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "bytes_total_persec"),
-			"This represents the total number of bytes per second traversing the virtual switch",
-			nil,
-			nil,
-		),
-		BytesReceivedPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "bytes_received_total_persec"),
-			"This represents the total number of bytes received per second by the virtual switch",
-			nil,
-			nil,
-		),
-		BytesSentPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "bytes_sent_total_persec"),
-			"This represents the total number of bytes sent per second by the virtual switch",
-			nil,
-			nil,
-		),
-		DirectedPacketsReceivedPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "directed_packets_received_total_persec"),
-			"This represents the total number of directed packets received per second by the virtual switch",
-			nil,
-			nil,
-		),
-		DirectedPacketsSentPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "directed_packets_send_total_persec"),
-			"This represents the total number of directed packets sent per second by the virtual switch",
-			nil,
-			nil,
-		),
-		DroppedPacketsIncomingPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "dropped_packets_incoming_total_persec"),
-			"This represents the total number of packet dropped per second by the virtual switch in the incoming direction",
-			nil,
-			nil,
-		),
-		DroppedPacketsOutgoingPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "dropped_packets_outcoming_total_persec"),
-			"This represents the total number of packet dropped per second by the virtual switch in the outgoing direction",
-			nil,
-			nil,
-		),
-		ExtensionsDroppedPacketsIncomingPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "extensions_dropped_packets_incoming_total_persec"),
-			"This represents the total number of packet dropped per second by the virtual switch extensions in the incoming direction",
-			nil,
-			nil,
-		),
-		ExtensionsDroppedPacketsOutgoingPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "extensions_dropped_packets_outcoming_total_persec"),
-			"This represents the total number of packet dropped per second by the virtual switch extensions in the outgoing direction",
-			nil,
-			nil,
-		),
-		LearnedMacAddresses: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "learned_mac_addresses"),
-			"This counter represents the total number of learned MAC addresses of the virtual switch",
-			nil,
-			nil,
-		),
-		LearnedMacAddressesPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "learned_mac_addresses_total_persec"),
-			"This represents the total number MAC addresses learned per second by the virtual switch",
-			nil,
-			nil,
-		),
-		MulticastPacketsReceivedPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "multicast_packets_received_total_persec"),
-			"This represents the total number of multicast packets received per second by the virtual switch",
-			nil,
-			nil,
-		),
-		MulticastPacketsSentPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "multicast_packets_sent_total_persec"),
-			"This represents the total number of multicast packets sent per second by the virtual switch",
-			nil,
-			nil,
-		),
-		NumberofSendChannelMovesPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "number_of_send_channel_moves_total_persec"),
-			"This represents the total number of send channel moves per second on this virtual switch",
-			nil,
-			nil,
-		),
-		NumberofVMQMovesPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "number_of_vmq_moves_total_persec"),
-			"This represents the total number of VMQ moves per second on this virtual switch",
-			nil,
-			nil,
-		),
-		PacketsFlooded: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "packets_flooded"),
-			"This counter represents the total number of packets flooded by the virtual switch",
-			nil,
-			nil,
-		),
-		PacketsFloodedPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "packets_flooded_total_persec"),
-			"This represents the total number of packets flooded per second by the virtual switch",
-			nil,
-			nil,
-		),
-		PacketsPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "packets_total_persec"),
-			"This represents the total number of packets per second traversing the virtual switch",
-			nil,
-			nil,
-		),
-		PacketsReceivedPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "packets_received_total_persec"),
-			"This represents the total number of packets received per second by the virtual switch",
-			nil,
-			nil,
-		),
-		PacketsSentPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "packets_sent_total_persec"),
-			"This represents the total number of packets send per second by the virtual switch",
-			nil,
-			nil,
-		),
-		PurgedMacAddresses: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "purged_mac_addresses"),
-			"This counter represents the total number of purged MAC addresses of the virtual switch",
-			nil,
-			nil,
-		),
-		PurgedMacAddressesPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "switch", "purged_mac_addresses_total_persec"),
-			"This represents the total number MAC addresses purged per second by the virtual switch",
-			nil,
-			nil,
-		),
-
-		//
-
-		AdapterBytesDropped: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "ethernet", "bytes_persec"),
-			"Bytes Dropped is the number of bytes dropped on the network adapter",
-			nil,
-			nil,
-		),
-		AdapterBytesReceivedPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "ethernet", "bytes_received_persec"),
-			"Bytes Received/sec is the number of bytes received per second on the network adapter",
-			nil,
-			nil,
-		),
-		AdapterBytesSentPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "ethernet", "bytes_sent_persec"),
-			"Bytes Sent/sec is the number of bytes sent per second over the network adapter",
-			nil,
-			nil,
-		),
-		AdapterFramesDropped: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "ethernet", "frames_dropped"),
-			"Frames Dropped is the number of frames dropped on the network adapter",
-			nil,
-			nil,
-		),
-		AdapterFramesReceivedPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "ethernet", "frames_received_persec"),
-			"Frames Received/sec is the number of frames received per second on the network adapter",
-			nil,
-			nil,
-		),
-		AdapterFramesSentPersec: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "ethernet", "frames_sent_persec"),
-			"Frames Sent/sec is the number of frames sent per second over the network adapter",
-			nil,
-			nil,
-		),
-	}, nil
+func (config Config) querier() Querier {
+	if config.Querier == nil {
+		return WMIQuerier{}
+	}
+	return config.Querier
 }
 
-// Collect sends the metric values for each metric
-// to the provided prometheus Metric channel.
-func (c *HyperVCollector) Collect(ch chan<- prometheus.Metric) error {
-	if desc, err := c.collectVmHealth(ch); err != nil {
-		log.Println("[ERROR] failed collecting hyperV health status metrics:", desc, err)
-		return err
+// classQuerier returns the querier of the queries of class, instrumented if
+// Metrics is set.
+func (config Config) classQuerier(class string) Querier {
+	if config.Metrics == nil {
+		return config.querier()
 	}
+	return instrumentedQuerier{querier: config.querier(), metrics: config.Metrics, class: class}
+}
 
-	if desc, err := c.collectVmVid(ch); err != nil {
-		log.Println("[ERROR] failed collecting hyperV pages metrics:", desc, err)
-		return err
+func (config Config) logger() log.Logger {
+	if config.Logger == nil {
+		return log.Base()
 	}
+	return config.Logger
+}
 
-	if desc, err := c.collectVmHv(ch); err != nil {
-		log.Println("[ERROR] failed collecting hyperV hv status metrics:", desc, err)
-		return err
+// Available returns the names of the built-in collectors, one per hyper-v
+// class, in collection order.
+func Available() []string {
+	var names []string
+	for _, spec := range hyperVClasses(Config{}) {
+		names = append(names, spec.Name)
 	}
+	return names
+}
 
-	if desc, err := c.collectVmProcessor(ch); err != nil {
-		log.Println("[ERROR] failed collecting hyperV processor metrics:", desc, err)
-		return err
+// NewCollectors builds the named built-in collectors, keyed by name.
+func NewCollectors(names []string, config Config) (map[string]Collector, error) {
+	specs := map[string]classSpec{}
+	for _, spec := range hyperVClasses(config) {
+		specs[spec.Name] = spec
 	}
 
-	if desc, err := c.collectVmRate(ch); err != nil {
-		log.Println("[ERROR] failed collecting hyperV rate metrics:", desc, err)
-		return err
+	collectors := map[string]Collector{}
+	for _, name := range names {
+		spec, ok := specs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+		c, err := newClassCollector(spec)
+		if err != nil {
+			return nil, err
+		}
+		c.querier = config.classQuerier(c.spec.Class)
+		c.logger = config.logger().With("collector", name).With("class", c.spec.Class)
+		c.vmInclude, c.vmExclude = config.VMInclude, config.VMExclude
+		collectors[name] = c
 	}
+	return collectors, nil
+}
 
-	if desc, err := c.collectVmSwitch(ch); err != nil {
-		log.Println("[ERROR] failed collecting hyperV switch metrics:", desc, err)
-		return err
+// hyperVClasses returns the specs of all hyper-v classes.
+func hyperVClasses(config Config) []classSpec {
+	root := rootPartitionClass
+	if config.LegacyMetricNames {
+		root.Metrics = append(append([]metricSpec{}, root.Metrics...), legacyRootPartitionMetrics...)
 	}
 
-	if desc, err := c.collectVmEthernet(ch); err != nil {
-		log.Println("[ERROR] failed collecting hyperV ethernet metrics:", desc, err)
-		return err
+	return []classSpec{
+		healthClass,
+		vidClass,
+		vmBusClass,
+		vmBusPipeClass,
+		root,
+		partitionClass,
+		processorClass,
+		rateClass,
+		switchClass,
+		ethernetClass,
 	}
-	return nil
 }
 
 // Win32_PerfRawData_VmmsVirtualMachineStats_HyperVVirtualMachineHealthSummary vm health status
@@ -528,28 +115,22 @@ type Win32_PerfRawData_VmmsVirtualMachineStats_HyperVVirtualMachineHealthSummary
 	HealthOk       uint32
 }
 
-func (c *HyperVCollector) collectVmHealth(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
-	var dst []Win32_PerfRawData_VmmsVirtualMachineStats_HyperVVirtualMachineHealthSummary
-	if err := wmi.Query(wmi.CreateQuery(&dst, ""), &dst); err != nil {
-		return nil, err
-	}
-
-	for _, health := range dst {
-		ch <- prometheus.MustNewConstMetric(
-			c.HealthCritical,
-			prometheus.GaugeValue,
-			float64(health.HealthCritical),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.HealthOk,
-			prometheus.GaugeValue,
-			float64(health.HealthOk),
-		)
-
-	}
-
-	return nil, nil
+var healthClass = classSpec{
+	Name:      "health",
+	Row:       Win32_PerfRawData_VmmsVirtualMachineStats_HyperVVirtualMachineHealthSummary{},
+	Subsystem: "health",
+	Metrics: []metricSpec{
+		{
+			Field: "HealthCritical",
+			Name:  "health_critical",
+			Help:  "This counter represents the number of virtual machines with critical health",
+		},
+		{
+			Field: "HealthOk",
+			Name:  "health_ok",
+			Help:  "This counter represents the number of virtual machines with ok health",
+		},
+	},
 }
 
 // Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition ..,
@@ -560,38 +141,114 @@ type Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition struct {
 	RemotePhysicalPages    uint64
 }
 
-func (c *HyperVCollector) collectVmVid(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
-	var dst []Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition
-	if err := wmi.Query(wmi.CreateQuery(&dst, ""), &dst); err != nil {
-		return nil, err
-	}
+var vidClass = classSpec{
+	Name:      "vid",
+	Row:       Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition{},
+	Subsystem: "vid",
+	SkipTotal: true,
+	Labels:    []labelSpec{{Field: "Name", Name: "vm", VM: true}},
+	Metrics: []metricSpec{
+		{
+			Field: "PhysicalPagesAllocated",
+			Name:  "physical_pages_allocated",
+			Help:  "The number of physical pages allocated",
+		},
+		{
+			Field: "PreferredNUMANodeIndex",
+			Name:  "preferred_numa_node_index",
+			Help:  "The preferred NUMA node index associated with this partition",
+		},
+		{
+			Field: "RemotePhysicalPages",
+			Name:  "remote_physical_pages",
+			Help:  "The number of physical pages not allocated from the preferred NUMA node",
+		},
+	},
+}
 
-	for _, page := range dst {
-		if strings.Contains(page.Name, "_Total") {
-			continue
-		}
+// Win32_PerfRawData_VmbusStats_HyperVVirtualMachineBus vmbus interrupt counters per partition
+type Win32_PerfRawData_VmbusStats_HyperVVirtualMachineBus struct {
+	Name                     string
+	InterruptsReceivedPersec uint64
+	InterruptsSentPersec     uint64
+	ThrottleEvents           uint64
+}
 
-		ch <- prometheus.MustNewConstMetric(
-			c.PhysicalPagesAllocated,
-			prometheus.GaugeValue,
-			float64(page.PhysicalPagesAllocated),
-		)
+var vmBusClass = classSpec{
+	Name:      "vmbus",
+	Row:       Win32_PerfRawData_VmbusStats_HyperVVirtualMachineBus{},
+	Subsystem: "vmbus",
+	SkipTotal: true,
+	Labels:    []labelSpec{{Field: "Name", Name: "vm", VM: true}},
+	Metrics: []metricSpec{
+		{
+			Field: "InterruptsReceivedPersec",
+			Name:  "interrupts_received_persec",
+			Help:  "The number of interrupts received per second by the partition from the virtual machine bus",
+		},
+		{
+			Field: "InterruptsSentPersec",
+			Name:  "interrupts_sent_persec",
+			Help:  "The number of interrupts sent per second by the partition over the virtual machine bus",
+		},
+		{
+			Field: "ThrottleEvents",
+			Name:  "throttle_events_total",
+			Help:  "The number of times the virtual machine bus throttled interrupts from the partition",
+			Type:  prometheus.CounterValue,
+		},
+	},
+}
 
-		ch <- prometheus.MustNewConstMetric(
-			c.PreferredNUMANodeIndex,
-			prometheus.GaugeValue,
-			float64(page.PreferredNUMANodeIndex),
-		)
+// Win32_PerfRawData_VmbusStats_HyperVVirtualMachineBusPipes vmbus pipe io counters, one instance per pipe of each VM
+type Win32_PerfRawData_VmbusStats_HyperVVirtualMachineBusPipes struct {
+	Name               string
+	BytesReadPersec    uint64
+	BytesWrittenPersec uint64
+	ReadsPersec        uint64
+	WritesPersec       uint64
+}
 
-		ch <- prometheus.MustNewConstMetric(
-			c.RemotePhysicalPages,
-			prometheus.GaugeValue,
-			float64(page.RemotePhysicalPages),
-		)
+var vmBusPipeClass = classSpec{
+	Name:      "vmbus_pipe",
+	Row:       Win32_PerfRawData_VmbusStats_HyperVVirtualMachineBusPipes{},
+	Subsystem: "vmbus_pipe",
+	SkipTotal: true,
+	Labels: []labelSpec{
+		{Field: "Name", Name: "vm", Value: partitionVMName, VM: true},
+		{Field: "Name", Name: "pipe", Value: pipeName},
+	},
+	Metrics: []metricSpec{
+		{
+			Field: "BytesReadPersec",
+			Name:  "bytes_read_persec",
+			Help:  "The number of bytes read per second from the virtual machine bus pipe",
+		},
+		{
+			Field: "BytesWrittenPersec",
+			Name:  "bytes_written_persec",
+			Help:  "The number of bytes written per second to the virtual machine bus pipe",
+		},
+		{
+			Field: "ReadsPersec",
+			Name:  "reads_persec",
+			Help:  "The number of reads per second from the virtual machine bus pipe",
+		},
+		{
+			Field: "WritesPersec",
+			Name:  "writes_persec",
+			Help:  "The number of writes per second to the virtual machine bus pipe",
+		},
+	},
+}
 
+// pipeName returns the pipe of a VMBus pipe instance name, which starts with
+// the name of its VM, e.g. "web01:pipe0" becomes "pipe0".
+func pipeName(name string) string {
+	if i := strings.LastIndex(name, ":"); i > 0 {
+		return name[i+1:]
 	}
-
-	return nil, nil
+	return name
 }
 
 // Win32_PerfRawData_HvStats_HyperVHypervisorRootPartition ...
@@ -620,135 +277,160 @@ type Win32_PerfRawData_HvStats_HyperVHypervisorRootPartition struct {
 	VirtualTLBPages               uint64
 }
 
-func (c *HyperVCollector) collectVmHv(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
-	var dst []Win32_PerfRawData_HvStats_HyperVHypervisorRootPartition
-	if err := wmi.Query(wmi.CreateQuery(&dst, ""), &dst); err != nil {
-		return nil, err
-	}
-
-	for _, obj := range dst {
-		if strings.Contains(obj.Name, "_Total") {
-			continue
-		}
-
-		ch <- prometheus.MustNewConstMetric(
-			c.AddressSpaces,
-			prometheus.GaugeValue,
-			float64(obj.AddressSpaces),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.AttachedDevices,
-			prometheus.GaugeValue,
-			float64(obj.AttachedDevices),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.DepositedPages,
-			prometheus.GaugeValue,
-			float64(obj.DepositedPages),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.DeviceDMAErrors,
-			prometheus.GaugeValue,
-			float64(obj.DeviceDMAErrors),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.DeviceInterruptErrors,
-			prometheus.GaugeValue,
-			float64(obj.DeviceInterruptErrors),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.DeviceInterruptThrottleEvents,
-			prometheus.GaugeValue,
-			float64(obj.DeviceInterruptThrottleEvents),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.GPAPages,
-			prometheus.GaugeValue,
-			float64(obj.GPAPages),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.GPASpaceModificationsPersec,
-			prometheus.GaugeValue,
-			float64(obj.GPASpaceModificationsPersec),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.IOTLBFlushCost,
-			prometheus.GaugeValue,
-			float64(obj.IOTLBFlushCost),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.IOTLBFlushesPersec,
-			prometheus.GaugeValue,
-			float64(obj.IOTLBFlushesPersec),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.RecommendedVirtualTLBSize,
-			prometheus.GaugeValue,
-			float64(obj.RecommendedVirtualTLBSize),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.SkippedTimerTicks,
-			prometheus.GaugeValue,
-			float64(obj.SkippedTimerTicks),
-		)
+// hvPartitionMetrics are shared by the root partition and the guest
+// partitions, which report the same counter set.
+var hvPartitionMetrics = []metricSpec{
+	{
+		Field: "AddressSpaces",
+		Name:  "address_spaces",
+		Help:  "The number of address spaces in the virtual TLB of the partition",
+	},
+	{
+		Field: "AttachedDevices",
+		Name:  "attached_devices",
+		Help:  "The number of devices attached to the partition",
+	},
+	{
+		Field: "DepositedPages",
+		Name:  "deposited_pages",
+		Help:  "The number of pages deposited into the partition",
+	},
+	{
+		Field: "DeviceDMAErrors",
+		Name:  "device_dma_errors",
+		Help:  "An indicator of illegal DMA requests generated by all devices assigned to the partition",
+	},
+	{
+		Field: "DeviceInterruptErrors",
+		Name:  "device_interrupt_errors",
+		Help:  "An indicator of illegal interrupt requests generated by all devices assigned to the partition",
+	},
+	{
+		Field: "DeviceInterruptMappings",
+		Name:  "device_interrupt_mappings",
+		Help:  "The number of device interrupt mappings used by the partition",
+	},
+	{
+		Field: "DeviceInterruptThrottleEvents",
+		Name:  "device_interrupt_throttle_events",
+		Help:  "The number of times an interrupt from a device assigned to the partition was temporarily throttled because the device was generating too many interrupts",
+	},
+	{
+		Field: "GPAPages",
+		Name:  "gpa_pages",
+		Help:  "The number of pages present in the GPA space of the partition (zero for root partition)",
+	},
+	{
+		Field: "GPASpaceModificationsPersec",
+		Name:  "gpa_space_modifications_persec",
+		Help:  "The rate of modifications to the GPA space of the partition",
+	},
+	{
+		Field: "IOTLBFlushCost",
+		Name:  "io_tlb_flush_cost",
+		Help:  "The average time (in nanoseconds) spent processing an I/O TLB flush",
+	},
+	{
+		Field: "IOTLBFlushesPersec",
+		Name:  "io_tlb_flush_persec",
+		Help:  "The rate of flushes of I/O TLBs of the partition",
+	},
+	{
+		Field: "RecommendedVirtualTLBSize",
+		Name:  "recommended_virtual_tlb_size",
+		Help:  "The recommended number of pages to be deposited for the virtual TLB",
+	},
+	{
+		Field: "SkippedTimerTicks",
+		Name:  "skipped_timer_ticks",
+		Help:  "The number of timer interrupts skipped for the partition",
+	},
+	{
+		Field: "Value1Gdevicepages",
+		Name:  "1G_device_pages",
+		Help:  "The number of 1G pages present in the device space of the partition",
+	},
+	{
+		Field: "Value1GGPApages",
+		Name:  "1G_gpa_pages",
+		Help:  "The number of 1G pages present in the GPA space of the partition",
+	},
+	{
+		Field: "Value2Mdevicepages",
+		Name:  "2M_device_pages",
+		Help:  "The number of 2M pages present in the device space of the partition",
+	},
+	{
+		Field: "Value2MGPApages",
+		Name:  "2M_gpa_pages",
+		Help:  "The number of 2M pages present in the GPA space of the partition",
+	},
+	{
+		Field: "Value4Kdevicepages",
+		Name:  "4K_device_pages",
+		Help:  "The number of 4K pages present in the device space of the partition",
+	},
+	{
+		Field: "Value4KGPApages",
+		Name:  "4K_gpa_pages",
+		Help:  "The number of 4K pages present in the GPA space of the partition",
+	},
+	{
+		Field: "VirtualTLBFlushEntiresPersec",
+		Name:  "virtual_tlb_flush_entires_persec",
+		Help:  "The rate of flushes of the entire virtual TLB",
+	},
+	{
+		Field: "VirtualTLBPages",
+		Name:  "virtual_tlb_pages",
+		Help:  "The number of pages used by the virtual TLB of the partition",
+	},
+}
 
-		ch <- prometheus.MustNewConstMetric(
-			c.Value1Gdevicepages,
-			prometheus.GaugeValue,
-			float64(obj.Value1Gdevicepages),
-		)
+// legacyRootPartitionMetrics expose GPAPages and SkippedTimerTicks under the
+// names they had up to the previous release, which collided with the NUMA
+// node and page allocation metrics.
+var legacyRootPartitionMetrics = []metricSpec{
+	{
+		Field: "GPAPages",
+		Name:  "preferred_numa_node_index",
+		Help:  "Deprecated: use hyperV_hv_gpa_pages instead",
+	},
+	{
+		Field: "SkippedTimerTicks",
+		Name:  "physical_pages_allocated",
+		Help:  "Deprecated: use hyperV_hv_skipped_timer_ticks instead",
+	},
+}
 
-		ch <- prometheus.MustNewConstMetric(
-			c.Value1GGPApages,
-			prometheus.GaugeValue,
-			float64(obj.Value1GGPApages),
-		)
+var rootPartitionClass = classSpec{
+	Name:      "hv",
+	Row:       Win32_PerfRawData_HvStats_HyperVHypervisorRootPartition{},
+	Subsystem: "hv",
+	SkipTotal: true,
+	Metrics:   hvPartitionMetrics,
+}
 
-		ch <- prometheus.MustNewConstMetric(
-			c.Value2Mdevicepages,
-			prometheus.GaugeValue,
-			float64(obj.Value2Mdevicepages),
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.Value2MGPApages,
-			prometheus.GaugeValue,
-			float64(obj.Value2MGPApages),
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.Value4Kdevicepages,
-			prometheus.GaugeValue,
-			float64(obj.Value4Kdevicepages),
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.Value4KGPApages,
-			prometheus.GaugeValue,
-			float64(obj.Value4KGPApages),
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.VirtualTLBFlushEntiresPersec,
-			prometheus.GaugeValue,
-			float64(obj.VirtualTLBFlushEntiresPersec),
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.VirtualTLBPages,
-			prometheus.GaugeValue,
-			float64(obj.VirtualTLBPages),
-		)
+// Win32_PerfRawData_HvStats_HyperVHypervisorPartition has the same counters as
+// the root partition, with one instance per guest VM
+type Win32_PerfRawData_HvStats_HyperVHypervisorPartition Win32_PerfRawData_HvStats_HyperVHypervisorRootPartition
+
+var partitionClass = classSpec{
+	Name:      "hv_partition",
+	Row:       Win32_PerfRawData_HvStats_HyperVHypervisorPartition{},
+	Subsystem: "hv_partition",
+	SkipTotal: true,
+	Labels:    []labelSpec{{Field: "Name", Name: "vm", Value: partitionVMName, VM: true}},
+	Metrics:   hvPartitionMetrics,
+}
 
+// partitionVMName strips the counter or pipe suffix from a partition or VMBus
+// pipe instance name, e.g. "web01:HvPt" becomes "web01".
+func partitionVMName(name string) string {
+	if i := strings.LastIndex(name, ":"); i > 0 {
+		return name[:i]
 	}
-
-	return nil, nil
+	return name
 }
 
 // Win32_PerfRawData_HvStats_HyperVHypervisor ...
@@ -757,29 +439,22 @@ type Win32_PerfRawData_HvStats_HyperVHypervisor struct {
 	VirtualProcessors uint64
 }
 
-func (c *HyperVCollector) collectVmProcessor(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
-	var dst []Win32_PerfRawData_HvStats_HyperVHypervisor
-	if err := wmi.Query(wmi.CreateQuery(&dst, ""), &dst); err != nil {
-		return nil, err
-	}
-
-	for _, obj := range dst {
-
-		ch <- prometheus.MustNewConstMetric(
-			c.LogicalProcessors,
-			prometheus.GaugeValue,
-			float64(obj.LogicalProcessors),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.VirtualProcessors,
-			prometheus.GaugeValue,
-			float64(obj.VirtualProcessors),
-		)
-
-	}
-
-	return nil, nil
+var processorClass = classSpec{
+	Name:      "processor",
+	Row:       Win32_PerfRawData_HvStats_HyperVHypervisor{},
+	Subsystem: "processor",
+	Metrics: []metricSpec{
+		{
+			Field: "LogicalProcessors",
+			Name:  "logical_processors",
+			Help:  "The number of logical processors present in the system",
+		},
+		{
+			Field: "VirtualProcessors",
+			Name:  "virtual_processors",
+			Help:  "The number of virtual processors present in the system",
+		},
+	},
 }
 
 // Win32_PerfRawData_HvStats_HyperVHypervisorRootVirtualProcessor ...
@@ -791,54 +466,41 @@ type Win32_PerfRawData_HvStats_HyperVHypervisorRootVirtualProcessor struct {
 	PercentTotalRunTime      uint64
 }
 
-func (c *HyperVCollector) collectVmRate(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
-	var dst []Win32_PerfRawData_HvStats_HyperVHypervisorRootVirtualProcessor
-	if err := wmi.Query(wmi.CreateQuery(&dst, ""), &dst); err != nil {
-		return nil, err
-	}
-
-	for _, obj := range dst {
-		if strings.Contains(obj.Name, "_Total") {
-			continue
-		}
-		// Root VP 3
-		names := strings.Split(obj.Name, " ")
-		if len(names) == 0 {
-			continue
-		}
-		label := names[len(names)-1]
-
-		ch <- prometheus.MustNewConstMetric(
-			c.PercentGuestRunTime,
-			prometheus.GaugeValue,
-			float64(obj.PercentGuestRunTime),
-			label,
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.PercentHypervisorRunTime,
-			prometheus.GaugeValue,
-			float64(obj.PercentHypervisorRunTime),
-			label,
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.PercentRemoteRunTime,
-			prometheus.GaugeValue,
-			float64(obj.PercentRemoteRunTime),
-			label,
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.PercentTotalRunTime,
-			prometheus.GaugeValue,
-			float64(obj.PercentTotalRunTime),
-			label,
-		)
-
-	}
+var rateClass = classSpec{
+	Name:      "rate",
+	Row:       Win32_PerfRawData_HvStats_HyperVHypervisorRootVirtualProcessor{},
+	Subsystem: "rate",
+	SkipTotal: true,
+	Labels:    []labelSpec{{Field: "Name", Name: "core", Value: virtualProcessorCore}},
+	Metrics: []metricSpec{
+		{
+			Field: "PercentGuestRunTime",
+			Name:  "guest_run_time",
+			Help:  "The percentage of time spent by the virtual processor in guest code",
+		},
+		{
+			Field: "PercentHypervisorRunTime",
+			Name:  "hypervisor_run_time",
+			Help:  "The percentage of time spent by the virtual processor in hypervisor code",
+		},
+		{
+			Field: "PercentRemoteRunTime",
+			Name:  "remote_run_time",
+			Help:  "The percentage of time spent by the virtual processor running on a remote node",
+		},
+		{
+			Field: "PercentTotalRunTime",
+			Name:  "total_run_time",
+			Help:  "The percentage of time spent by the virtual processor in guest and hypervisor code",
+		},
+	},
+}
 
-	return nil, nil
+// virtualProcessorCore returns the index of a virtual processor from its
+// instance name, e.g. "Root VP 3" becomes "3".
+func virtualProcessorCore(name string) string {
+	names := strings.Split(name, " ")
+	return names[len(names)-1]
 }
 
 // Win32_PerfRawData_NvspSwitchStats_HyperVVirtualSwitch ...
@@ -870,149 +532,136 @@ type Win32_PerfRawData_NvspSwitchStats_HyperVVirtualSwitch struct {
 	PurgedMacAddressesPersec               uint64
 }
 
-func (c *HyperVCollector) collectVmSwitch(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
-	var dst []Win32_PerfRawData_NvspSwitchStats_HyperVVirtualSwitch
-	if err := wmi.Query(wmi.CreateQuery(&dst, ""), &dst); err != nil {
-		return nil, err
-	}
-
-	for _, obj := range dst {
-		if strings.Contains(obj.Name, "_Total") {
-			continue
-		}
-
-		ch <- prometheus.MustNewConstMetric(
-			c.BroadcastPacketsReceivedPersec,
-			prometheus.GaugeValue,
-			float64(obj.BroadcastPacketsReceivedPersec),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.BroadcastPacketsSentPersec,
-			prometheus.GaugeValue,
-			float64(obj.BroadcastPacketsSentPersec),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.BytesPersec,
-			prometheus.GaugeValue,
-			float64(obj.BytesPersec),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.BytesReceivedPersec,
-			prometheus.GaugeValue,
-			float64(obj.BytesReceivedPersec),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.BytesSentPersec,
-			prometheus.GaugeValue,
-			float64(obj.BytesSentPersec),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.DirectedPacketsReceivedPersec,
-			prometheus.GaugeValue,
-			float64(obj.DirectedPacketsReceivedPersec),
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DirectedPacketsSentPersec,
-			prometheus.GaugeValue,
-			float64(obj.DirectedPacketsSentPersec),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.DroppedPacketsIncomingPersec,
-			prometheus.GaugeValue,
-			float64(obj.DroppedPacketsIncomingPersec),
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.DroppedPacketsOutgoingPersec,
-			prometheus.GaugeValue,
-			float64(obj.DroppedPacketsOutgoingPersec),
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.ExtensionsDroppedPacketsIncomingPersec,
-			prometheus.GaugeValue,
-			float64(obj.ExtensionsDroppedPacketsIncomingPersec),
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.ExtensionsDroppedPacketsOutgoingPersec,
-			prometheus.GaugeValue,
-			float64(obj.ExtensionsDroppedPacketsOutgoingPersec),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.LearnedMacAddresses,
-			prometheus.CounterValue,
-			float64(obj.LearnedMacAddresses),
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.LearnedMacAddressesPersec,
-			prometheus.GaugeValue,
-			float64(obj.LearnedMacAddressesPersec),
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.MulticastPacketsReceivedPersec,
-			prometheus.GaugeValue,
-			float64(obj.MulticastPacketsReceivedPersec),
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.MulticastPacketsSentPersec,
-			prometheus.GaugeValue,
-			float64(obj.MulticastPacketsSentPersec),
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.NumberofSendChannelMovesPersec,
-			prometheus.GaugeValue,
-			float64(obj.NumberofSendChannelMovesPersec),
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.NumberofVMQMovesPersec,
-			prometheus.GaugeValue,
-			float64(obj.NumberofVMQMovesPersec),
-		)
-
-		// ...
-		ch <- prometheus.MustNewConstMetric(
-			c.PacketsFlooded,
-			prometheus.CounterValue,
-			float64(obj.PacketsFlooded),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.PacketsFloodedPersec,
-			prometheus.GaugeValue,
-			float64(obj.PacketsFloodedPersec),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.PacketsPersec,
-			prometheus.GaugeValue,
-			float64(obj.PacketsPersec),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.PacketsReceivedPersec,
-			prometheus.GaugeValue,
-			float64(obj.PacketsReceivedPersec),
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.PurgedMacAddresses,
-			prometheus.CounterValue,
-			float64(obj.PurgedMacAddresses),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.PurgedMacAddressesPersec,
-			prometheus.GaugeValue,
-			float64(obj.PurgedMacAddressesPersec),
-		)
-
-	}
-
-	return nil, nil
+var switchClass = classSpec{
+	Name:      "switch",
+	Row:       Win32_PerfRawData_NvspSwitchStats_HyperVVirtualSwitch{},
+	Subsystem: "switch",
+	SkipTotal: true,
+	Metrics: []metricSpec{
+		{
+			Field: "BroadcastPacketsReceivedPersec",
+			Name:  "broadcast_packets_received_total_persec",
+			Help:  "This represents the total number of broadcast packets received per second by the virtual switch",
+		},
+		{
+			Field: "BroadcastPacketsSentPersec",
+			Name:  "broadcast_packets_sent_total_persec",
+			Help:  "This represents the total number of broadcast packets sent per second by the virtual switch",
+		},
+		{
+			Field: "BytesPersec",
+			Name:  "bytes_total_persec",
+			Help:  "This represents the total number of bytes per second traversing the virtual switch",
+		},
+		{
+			Field: "BytesReceivedPersec",
+			Name:  "bytes_received_total_persec",
+			Help:  "This represents the total number of bytes received per second by the virtual switch",
+		},
+		{
+			Field: "BytesSentPersec",
+			Name:  "bytes_sent_total_persec",
+			Help:  "This represents the total number of bytes sent per second by the virtual switch",
+		},
+		{
+			Field: "DirectedPacketsReceivedPersec",
+			Name:  "directed_packets_received_total_persec",
+			Help:  "This represents the total number of directed packets received per second by the virtual switch",
+		},
+		{
+			Field: "DirectedPacketsSentPersec",
+			Name:  "directed_packets_send_total_persec",
+			Help:  "This represents the total number of directed packets sent per second by the virtual switch",
+		},
+		{
+			Field: "DroppedPacketsIncomingPersec",
+			Name:  "dropped_packets_incoming_total_persec",
+			Help:  "This represents the total number of packet dropped per second by the virtual switch in the incoming direction",
+		},
+		{
+			Field: "DroppedPacketsOutgoingPersec",
+			Name:  "dropped_packets_outcoming_total_persec",
+			Help:  "This represents the total number of packet dropped per second by the virtual switch in the outgoing direction",
+		},
+		{
+			Field: "ExtensionsDroppedPacketsIncomingPersec",
+			Name:  "extensions_dropped_packets_incoming_total_persec",
+			Help:  "This represents the total number of packet dropped per second by the virtual switch extensions in the incoming direction",
+		},
+		{
+			Field: "ExtensionsDroppedPacketsOutgoingPersec",
+			Name:  "extensions_dropped_packets_outcoming_total_persec",
+			Help:  "This represents the total number of packet dropped per second by the virtual switch extensions in the outgoing direction",
+		},
+		{
+			Field: "LearnedMacAddresses",
+			Name:  "learned_mac_addresses",
+			Help:  "This counter represents the total number of learned MAC addresses of the virtual switch",
+			Type:  prometheus.CounterValue,
+		},
+		{
+			Field: "LearnedMacAddressesPersec",
+			Name:  "learned_mac_addresses_total_persec",
+			Help:  "This represents the total number MAC addresses learned per second by the virtual switch",
+		},
+		{
+			Field: "MulticastPacketsReceivedPersec",
+			Name:  "multicast_packets_received_total_persec",
+			Help:  "This represents the total number of multicast packets received per second by the virtual switch",
+		},
+		{
+			Field: "MulticastPacketsSentPersec",
+			Name:  "multicast_packets_sent_total_persec",
+			Help:  "This represents the total number of multicast packets sent per second by the virtual switch",
+		},
+		{
+			Field: "NumberofSendChannelMovesPersec",
+			Name:  "number_of_send_channel_moves_total_persec",
+			Help:  "This represents the total number of send channel moves per second on this virtual switch",
+		},
+		{
+			Field: "NumberofVMQMovesPersec",
+			Name:  "number_of_vmq_moves_total_persec",
+			Help:  "This represents the total number of VMQ moves per second on this virtual switch",
+		},
+		{
+			Field: "PacketsFlooded",
+			Name:  "packets_flooded",
+			Help:  "This counter represents the total number of packets flooded by the virtual switch",
+			Type:  prometheus.CounterValue,
+		},
+		{
+			Field: "PacketsFloodedPersec",
+			Name:  "packets_flooded_total_persec",
+			Help:  "This represents the total number of packets flooded per second by the virtual switch",
+		},
+		{
+			Field: "PacketsPersec",
+			Name:  "packets_total_persec",
+			Help:  "This represents the total number of packets per second traversing the virtual switch",
+		},
+		{
+			Field: "PacketsReceivedPersec",
+			Name:  "packets_received_total_persec",
+			Help:  "This represents the total number of packets received per second by the virtual switch",
+		},
+		{
+			Field: "PacketsSentPersec",
+			Name:  "packets_sent_total_persec",
+			Help:  "This represents the total number of packets send per second by the virtual switch",
+		},
+		{
+			Field: "PurgedMacAddresses",
+			Name:  "purged_mac_addresses",
+			Help:  "This counter represents the total number of purged MAC addresses of the virtual switch",
+			Type:  prometheus.CounterValue,
+		},
+		{
+			Field: "PurgedMacAddressesPersec",
+			Name:  "purged_mac_addresses_total_persec",
+			Help:  "This represents the total number MAC addresses purged per second by the virtual switch",
+		},
+	},
 }
 
 // Win32_PerfRawData_EthernetPerfProvider_HyperVLegacyNetworkAdapter ...
@@ -1026,54 +675,44 @@ type Win32_PerfRawData_EthernetPerfProvider_HyperVLegacyNetworkAdapter struct {
 	FramesSentPersec     uint64
 }
 
-func (c *HyperVCollector) collectVmEthernet(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
-	var dst []Win32_PerfRawData_EthernetPerfProvider_HyperVLegacyNetworkAdapter
-	if err := wmi.Query(wmi.CreateQuery(&dst, ""), &dst); err != nil {
-		return nil, err
-	}
-
-	for _, obj := range dst {
-		if strings.Contains(obj.Name, "_Total") {
-			continue
-		}
-
-		ch <- prometheus.MustNewConstMetric(
-			c.AdapterBytesDropped,
-			prometheus.GaugeValue,
-			float64(obj.BytesDropped),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.AdapterBytesReceivedPersec,
-			prometheus.GaugeValue,
-			float64(obj.BytesReceivedPersec),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.AdapterBytesSentPersec,
-			prometheus.GaugeValue,
-			float64(obj.BytesSentPersec),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.AdapterFramesReceivedPersec,
-			prometheus.GaugeValue,
-			float64(obj.FramesReceivedPersec),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.AdapterFramesDropped,
-			prometheus.GaugeValue,
-			float64(obj.BytesSentPersec),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.AdapterFramesSentPersec,
-			prometheus.GaugeValue,
-			float64(obj.FramesSentPersec),
-		)
-
-	}
-
-	return nil, nil
+var ethernetClass = classSpec{
+	Name:      "ethernet",
+	Row:       Win32_PerfRawData_EthernetPerfProvider_HyperVLegacyNetworkAdapter{},
+	Subsystem: "ethernet",
+	SkipTotal: true,
+	// Adapter instance names include the name of their VM, so the VM
+	// filters are matched against them.
+	Labels: []labelSpec{{Field: "Name", Name: "adapter", VM: true}},
+	Metrics: []metricSpec{
+		{
+			Field: "BytesDropped",
+			Name:  "bytes_persec",
+			Help:  "Bytes Dropped is the number of bytes dropped on the network adapter",
+		},
+		{
+			Field: "BytesReceivedPersec",
+			Name:  "bytes_received_persec",
+			Help:  "Bytes Received/sec is the number of bytes received per second on the network adapter",
+		},
+		{
+			Field: "BytesSentPersec",
+			Name:  "bytes_sent_persec",
+			Help:  "Bytes Sent/sec is the number of bytes sent per second over the network adapter",
+		},
+		{
+			Field: "FramesDropped",
+			Name:  "frames_dropped",
+			Help:  "Frames Dropped is the number of frames dropped on the network adapter",
+		},
+		{
+			Field: "FramesReceivedPersec",
+			Name:  "frames_received_persec",
+			Help:  "Frames Received/sec is the number of frames received per second on the network adapter",
+		},
+		{
+			Field: "FramesSentPersec",
+			Name:  "frames_sent_persec",
+			Help:  "Frames Sent/sec is the number of frames sent per second over the network adapter",
+		},
+	},
 }
@@ -0,0 +1,174 @@
+package collector
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var configs = map[string]Config{
+	"default": {},
+	"legacy":  {LegacyMetricNames: true},
+}
+
+// fixtureRows returns a slice holding a single row of the class, named after
+// a VM when the row has a Name field.
+func fixtureRows(c *classCollector) reflect.Value {
+	row := reflect.New(c.row).Elem()
+	if name := row.FieldByName("Name"); name.IsValid() {
+		name.SetString("vm01")
+	}
+	return reflect.Append(reflect.MakeSlice(reflect.SliceOf(c.row), 0, 1), row)
+}
+
+func TestEveryDescIsCollected(t *testing.T) {
+	for name, config := range configs {
+		collectors, err := NewCollectors(Available(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range collectors {
+			class := c.(*classCollector)
+			ch := make(chan prometheus.Metric, len(class.descs))
+			class.collectRows(ch, fixtureRows(class))
+			close(ch)
+
+			collected := map[*prometheus.Desc]bool{}
+			for m := range ch {
+				collected[m.Desc()] = true
+			}
+
+			descs := make(chan *prometheus.Desc, len(class.descs))
+			class.Describe(descs)
+			close(descs)
+			for d := range descs {
+				if !collected[d] {
+					t.Errorf("%s: %s is described but not collected", name, d)
+				}
+			}
+		}
+	}
+}
+
+func TestTotalInstanceIsSkipped(t *testing.T) {
+	class, err := newClassCollector(vidClass)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := fixtureRows(class)
+	rows.Index(0).FieldByName("Name").SetString("_Total")
+
+	ch := make(chan prometheus.Metric, len(class.descs))
+	class.collectRows(ch, rows)
+	if len(ch) != 0 {
+		t.Errorf("collected %d metrics for the _Total instance", len(ch))
+	}
+}
+
+func TestVMFilter(t *testing.T) {
+	for name, test := range map[string]struct {
+		config Config
+		vm     string
+		want   bool
+	}{
+		"no filter":         {Config{}, "vdi-01", true},
+		"included":          {Config{VMInclude: regexp.MustCompile("^web")}, "web01", true},
+		"not included":      {Config{VMInclude: regexp.MustCompile("^web")}, "vdi-01", false},
+		"excluded":          {Config{VMExclude: regexp.MustCompile("^vdi-")}, "vdi-01", false},
+		"not excluded":      {Config{VMExclude: regexp.MustCompile("^vdi-")}, "web01", true},
+		"exclude overrides": {Config{VMInclude: regexp.MustCompile(".*"), VMExclude: regexp.MustCompile("vdi")}, "vdi-01", false},
+	} {
+		collectors, err := NewCollectors([]string{"vid", "hv_partition", "vmbus_pipe", "processor"}, test.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for cname, c := range collectors {
+			class := c.(*classCollector)
+			rows := fixtureRows(class)
+			if f := rows.Index(0).FieldByName("Name"); f.IsValid() {
+				f.SetString(test.vm)
+			}
+
+			ch := make(chan prometheus.Metric, len(class.descs))
+			class.collectRows(ch, rows)
+			// The processor class is host-wide and never filtered.
+			want := test.want || cname == "processor"
+			if got := len(ch) > 0; got != want {
+				t.Errorf("%s: %s: collected %v, want %v", name, cname, got, want)
+			}
+		}
+	}
+}
+
+func TestVMFilterUsesLabelValue(t *testing.T) {
+	collectors, err := NewCollectors([]string{"hv_partition"}, Config{VMInclude: regexp.MustCompile("^web01$")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	class := collectors["hv_partition"].(*classCollector)
+	rows := fixtureRows(class)
+	rows.Index(0).FieldByName("Name").SetString("web01:HvPt")
+
+	ch := make(chan prometheus.Metric, len(class.descs))
+	class.collectRows(ch, rows)
+	if len(ch) == 0 {
+		t.Error("the filter should match the VM name without the counter suffix")
+	}
+}
+
+// describeOnly adapts a Collector to prometheus.Collector without collecting
+// anything, so registration can validate the descriptors.
+type describeOnly struct {
+	Collector
+}
+
+func (describeOnly) Collect(ch chan<- prometheus.Metric) {}
+
+func TestDescsAreUnique(t *testing.T) {
+	for name, config := range configs {
+		collectors, err := NewCollectors(Available(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reg := prometheus.NewPedanticRegistry()
+		for cname, c := range collectors {
+			if err := reg.Register(describeOnly{c}); err != nil {
+				t.Errorf("%s: %s: %s", name, cname, err)
+			}
+		}
+	}
+}
+
+func TestUnknownCollector(t *testing.T) {
+	if _, err := NewCollectors([]string{"vid", "cpu"}, Config{}); err == nil {
+		t.Error("expected an error for an unknown collector")
+	}
+}
+
+func TestClassSpecValidation(t *testing.T) {
+	for name, spec := range map[string]classSpec{
+		"missing metric field": {
+			Row:     Win32_PerfRawData_HvStats_HyperVHypervisor{},
+			Metrics: []metricSpec{{Field: "Missing", Name: "missing"}},
+		},
+		"string metric field": {
+			Row:     Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition{},
+			Metrics: []metricSpec{{Field: "Name", Name: "name"}},
+		},
+		"numeric label field": {
+			Row:    Win32_PerfRawData_HvStats_HyperVHypervisor{},
+			Labels: []labelSpec{{Field: "LogicalProcessors", Name: "lp"}},
+		},
+		"row not a struct": {
+			Row: "Win32_PerfRawData_HvStats_HyperVHypervisor",
+		},
+	} {
+		if _, err := newClassCollector(spec); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
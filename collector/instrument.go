@@ -0,0 +1,134 @@
+package collector
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// QueryMetrics instruments the WMI queries of the collectors, by class. It is
+// meant to be created once and shared by the collectors of successive
+// configurations, so that its counters are not reset on reload. It
+// implements prometheus.Collector.
+type QueryMetrics struct {
+	duration *prometheus.HistogramVec
+	rows     *prometheus.GaugeVec
+	errors   *prometheus.CounterVec
+	inFlight *prometheus.GaugeVec
+	scrapes  prometheus.Gauge
+}
+
+// NewQueryMetrics returns the metrics of the queries, exposed under
+// hyperV_exporter_wmi_.
+func NewQueryMetrics() *QueryMetrics {
+	return &QueryMetrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "wmi_query_duration_seconds",
+			Help:      "hyperV_exporter: Duration of the WMI queries.",
+			Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"class"}),
+		rows: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "wmi_query_rows",
+			Help:      "hyperV_exporter: Number of rows returned by the last successful WMI query.",
+		}, []string{"class"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "wmi_query_errors_total",
+			Help:      "hyperV_exporter: Number of failed WMI queries.",
+		}, []string{"class", "type"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "wmi_queries_in_flight",
+			Help:      "hyperV_exporter: Number of WMI queries running.",
+		}, []string{"class"}),
+		scrapes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: "exporter",
+			Name:      "wmi_scrapes_in_flight",
+			Help:      "hyperV_exporter: Number of collections of the host running.",
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *QueryMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.duration.Describe(ch)
+	m.rows.Describe(ch)
+	m.errors.Describe(ch)
+	m.inFlight.Describe(ch)
+	m.scrapes.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *QueryMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.duration.Collect(ch)
+	m.rows.Collect(ch)
+	m.errors.Collect(ch)
+	m.inFlight.Collect(ch)
+	m.scrapes.Collect(ch)
+}
+
+// ScrapeStarted counts a collection as in flight until the returned function
+// is called. It does nothing on nil metrics.
+func (m *QueryMetrics) ScrapeStarted() (done func()) {
+	if m == nil {
+		return func() {}
+	}
+	m.scrapes.Inc()
+	return m.scrapes.Dec
+}
+
+// instrumentedQuerier records the queries of a class in metrics.
+type instrumentedQuerier struct {
+	querier Querier
+	metrics *QueryMetrics
+	class   string
+}
+
+// Query implements Querier.
+func (q instrumentedQuerier) Query(query string, dst interface{}, namespace string) error {
+	inFlight := q.metrics.inFlight.WithLabelValues(q.class)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	begin := time.Now()
+	err := q.querier.Query(query, dst, namespace)
+	q.metrics.duration.WithLabelValues(q.class).Observe(time.Since(begin).Seconds())
+	if err != nil {
+		q.metrics.errors.WithLabelValues(q.class, errorType(err)).Inc()
+		return err
+	}
+	q.metrics.rows.WithLabelValues(q.class).Set(float64(reflect.Indirect(reflect.ValueOf(dst)).Len()))
+	return nil
+}
+
+// errorTypes name the HRESULTs of the common query errors.
+var errorTypes = map[uint32]string{
+	0x80070005: "access_denied",     // E_ACCESSDENIED
+	0x80041003: "access_denied",     // WBEM_E_ACCESS_DENIED
+	0x80041010: "invalid_class",     // WBEM_E_INVALID_CLASS
+	0x80041017: "invalid_query",     // WBEM_E_INVALID_QUERY
+	0x8004100E: "invalid_namespace", // WBEM_E_INVALID_NAMESPACE
+	0x80043001: "timeout",           // WBEM_E_TIMED_OUT
+}
+
+// errorType classifies err for the type label of
+// hyperV_exporter_wmi_query_errors_total.
+func errorType(err error) string {
+	if _, ok := err.(notConnectedError); ok || isConnectionError(err) {
+		return "connection"
+	}
+	if code, ok := hresult(err); ok {
+		if t, ok := errorTypes[code]; ok {
+			return t
+		}
+	}
+	return "other"
+}
@@ -0,0 +1,70 @@
+package collector
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestQueryMetrics(t *testing.T) {
+	metrics := NewQueryMetrics()
+	ch := make(chan prometheus.Metric, 100)
+
+	collectors, err := NewCollectors([]string{"vid"}, Config{Querier: rowsQuerier{rows: 3}, Metrics: metrics})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := collectors["vid"].Collect(ch); err != nil {
+		t.Fatal(err)
+	}
+	collectors, err = NewCollectors([]string{"vid"}, Config{Querier: rowsQuerier{err: notConnectedError("not connected")}, Metrics: metrics})
+	if err != nil {
+		t.Fatal(err)
+	}
+	collectors["vid"].Collect(ch)
+	collectors, err = NewCollectors([]string{"vid"}, Config{Querier: rowsQuerier{err: errors.New("unknown")}, Metrics: metrics})
+	if err != nil {
+		t.Fatal(err)
+	}
+	collectors["vid"].Collect(ch)
+
+	done := metrics.ScrapeStarted()
+	want := `
+# HELP hyperV_exporter_wmi_queries_in_flight hyperV_exporter: Number of WMI queries running.
+# TYPE hyperV_exporter_wmi_queries_in_flight gauge
+hyperV_exporter_wmi_queries_in_flight{class="Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition"} 0
+# HELP hyperV_exporter_wmi_query_errors_total hyperV_exporter: Number of failed WMI queries.
+# TYPE hyperV_exporter_wmi_query_errors_total counter
+hyperV_exporter_wmi_query_errors_total{class="Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition",type="connection"} 1
+hyperV_exporter_wmi_query_errors_total{class="Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition",type="other"} 1
+# HELP hyperV_exporter_wmi_query_rows hyperV_exporter: Number of rows returned by the last successful WMI query.
+# TYPE hyperV_exporter_wmi_query_rows gauge
+hyperV_exporter_wmi_query_rows{class="Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition"} 3
+# HELP hyperV_exporter_wmi_scrapes_in_flight hyperV_exporter: Number of collections of the host running.
+# TYPE hyperV_exporter_wmi_scrapes_in_flight gauge
+hyperV_exporter_wmi_scrapes_in_flight 1
+`
+	names := []string{
+		"hyperV_exporter_wmi_queries_in_flight",
+		"hyperV_exporter_wmi_query_errors_total",
+		"hyperV_exporter_wmi_query_rows",
+		"hyperV_exporter_wmi_scrapes_in_flight",
+	}
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(want), names...); err != nil {
+		t.Error(err)
+	}
+	done()
+	if got := testutil.ToFloat64(metrics.scrapes); got != 0 {
+		t.Errorf("got %v scrapes in flight after the scrape, want 0", got)
+	}
+	if got := testutil.CollectAndCount(metrics, "hyperV_exporter_wmi_query_duration_seconds"); got != 1 {
+		t.Errorf("got %d duration histograms, want 1", got)
+	}
+
+	// Without metrics, nothing is recorded.
+	var none *QueryMetrics
+	none.ScrapeStarted()()
+}
@@ -0,0 +1,126 @@
+package collector
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// setField stores v, the value of a WMI property, in f. Integer properties
+// are converted to any numeric field that can hold them, and 64-bit integers,
+// which WMI returns as strings, are parsed. Arrays are stored in slices.
+// Null properties, passed as nil, leave f unchanged.
+func setField(f reflect.Value, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case int8, int16, int32, int64, int:
+		return setInt(f, reflect.ValueOf(v).Int())
+	case uint8, uint16, uint32, uint64, uint:
+		return setUint(f, reflect.ValueOf(v).Uint())
+	case float32:
+		return setFloat(f, float64(v))
+	case float64:
+		return setFloat(f, v)
+	case bool:
+		if f.Kind() != reflect.Bool {
+			return fmt.Errorf("cannot store a boolean in a %s field", f.Type())
+		}
+		f.SetBool(v)
+		return nil
+	case string:
+		return setString(f, v)
+	case []interface{}:
+		if f.Kind() != reflect.Slice {
+			return fmt.Errorf("cannot store an array in a %s field", f.Type())
+		}
+		s := reflect.MakeSlice(f.Type(), len(v), len(v))
+		for i, e := range v {
+			if err := setField(s.Index(i), e); err != nil {
+				return err
+			}
+		}
+		f.Set(s)
+		return nil
+	}
+	return fmt.Errorf("unsupported value of type %T", v)
+}
+
+func setInt(f reflect.Value, n int64) error {
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if f.OverflowInt(n) {
+			break
+		}
+		f.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n < 0 || f.OverflowUint(uint64(n)) {
+			break
+		}
+		f.SetUint(uint64(n))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f.SetFloat(float64(n))
+		return nil
+	}
+	return fmt.Errorf("cannot store %d in a %s field", n, f.Type())
+}
+
+func setUint(f reflect.Value, n uint64) error {
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n > math.MaxInt64 || f.OverflowInt(int64(n)) {
+			break
+		}
+		f.SetInt(int64(n))
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if f.OverflowUint(n) {
+			break
+		}
+		f.SetUint(n)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f.SetFloat(float64(n))
+		return nil
+	}
+	return fmt.Errorf("cannot store %d in a %s field", n, f.Type())
+}
+
+func setFloat(f reflect.Value, n float64) error {
+	switch f.Kind() {
+	case reflect.Float32, reflect.Float64:
+		f.SetFloat(n)
+		return nil
+	}
+	return fmt.Errorf("cannot store %v in a %s field", n, f.Type())
+}
+
+func setString(f reflect.Value, s string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		return setInt(f, n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		return setUint(f, n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		return setFloat(f, n)
+	}
+	return fmt.Errorf("cannot store a string in a %s field", f.Type())
+}
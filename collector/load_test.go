@@ -0,0 +1,51 @@
+package collector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSetField(t *testing.T) {
+	var row struct {
+		Int    int64
+		Uint   uint64
+		Uint32 uint32
+		Float  float64
+		Name   string
+		Notes  []string
+	}
+	v := reflect.ValueOf(&row).Elem()
+	for field, value := range map[string]interface{}{
+		"Int":    int32(-1),
+		"Uint":   "18446744073709551615",
+		"Uint32": uint32(7),
+		"Float":  float32(0.5),
+		"Name":   "vm01",
+		"Notes":  []interface{}{"team=web", "owner=ops"},
+	} {
+		if err := setField(v.FieldByName(field), value); err != nil {
+			t.Errorf("%s: %s", field, err)
+		}
+	}
+	if row.Int != -1 || row.Uint != 1<<64-1 || row.Uint32 != 7 || row.Float != 0.5 || row.Name != "vm01" ||
+		!reflect.DeepEqual(row.Notes, []string{"team=web", "owner=ops"}) {
+		t.Errorf("unexpected row %+v", row)
+	}
+
+	// Null properties leave the field unchanged.
+	if err := setField(v.FieldByName("Name"), nil); err != nil || row.Name != "vm01" {
+		t.Errorf("got %q, %v for a null property", row.Name, err)
+	}
+
+	for field, value := range map[string]interface{}{
+		"Uint":   int32(-1),
+		"Uint32": uint64(1 << 32),
+		"Int":    float64(1.5),
+		"Name":   true,
+		"Float":  "not a number",
+	} {
+		if err := setField(v.FieldByName(field), value); err == nil {
+			t.Errorf("%s: expected an error storing %v (%T)", field, value, value)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package collector
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// metricSpec describes how a numeric field of a WMI class is exposed.
+type metricSpec struct {
+	// Field is the name of the struct field holding the value.
+	Field string
+	// Name is the metric name below the subsystem of the class.
+	Name string
+	Help string
+	// Type defaults to prometheus.GaugeValue.
+	Type prometheus.ValueType
+	// Scale multiplies the raw value, e.g. ticksToSecondsScaleFactor. It
+	// defaults to 1.
+	Scale float64
+}
+
+// labelSpec derives a variable label from a string field of a WMI class.
+type labelSpec struct {
+	Field string
+	Name  string
+	// Value optionally rewrites the field value, e.g. to strip the counter
+	// suffix from an instance name.
+	Value func(string) string
+	// VM marks the label identifying the VM a row belongs to. Rows are
+	// filtered on its value with Config.VMInclude and Config.VMExclude.
+	VM bool
+}
+
+// classSpec declares a WMI class and how its rows are turned into metrics.
+// Exposing a new class only takes a struct for its rows and a classSpec.
+type classSpec struct {
+	// Name identifies the class in logs. For the built-in classes it is
+	// also the name of the collector.
+	Name string
+	// Row is a zero value of the struct the rows are decoded into. The class
+	// queried is the name of its type, unless Class is set.
+	Row   interface{}
+	Class string
+	// Namespace is the WMI namespace of the class, root\cimv2 if unset.
+	Namespace string
+	// Where is an optional WQL condition, starting with "WHERE".
+	Where     string
+	Subsystem string
+	// SkipTotal skips the _Total instance of performance counter classes,
+	// which only aggregates the other rows.
+	SkipTotal bool
+	Labels    []labelSpec
+	Metrics   []metricSpec
+}
+
+// classCollector queries a single WMI class and emits the metrics declared by
+// its classSpec.
+type classCollector struct {
+	spec  classSpec
+	row   reflect.Type
+	descs []*prometheus.Desc
+
+	querier Querier
+	logger  log.Logger
+	// vmInclude and vmExclude filter rows on the value of the VM label of
+	// the class, if it has one.
+	vmInclude, vmExclude *regexp.Regexp
+}
+
+// newClassCollector validates spec against its row struct and builds the
+// Descs of its metrics.
+func newClassCollector(spec classSpec) (*classCollector, error) {
+	row := reflect.TypeOf(spec.Row)
+	if row == nil || row.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s: row must be a struct, got %T", spec.Name, spec.Row)
+	}
+	if spec.Class == "" {
+		spec.Class = row.Name()
+	}
+
+	var labels []string
+	for _, l := range spec.Labels {
+		if f, ok := row.FieldByName(l.Field); !ok || f.Type.Kind() != reflect.String {
+			return nil, fmt.Errorf("%s: label %q needs a string field %s in %s", spec.Name, l.Name, l.Field, spec.Class)
+		}
+		labels = append(labels, l.Name)
+	}
+
+	c := &classCollector{spec: spec, row: row, querier: WMIQuerier{}, logger: log.Base()}
+	c.spec.Metrics = make([]metricSpec, len(spec.Metrics))
+	for i, m := range spec.Metrics {
+		f, ok := row.FieldByName(m.Field)
+		if !ok || !isNumeric(f.Type.Kind()) {
+			return nil, fmt.Errorf("%s: metric %q needs a numeric field %s in %s", spec.Name, m.Name, m.Field, spec.Class)
+		}
+		if m.Type == 0 {
+			m.Type = prometheus.GaugeValue
+		}
+		if m.Scale == 0 {
+			m.Scale = 1
+		}
+		c.spec.Metrics[i] = m
+		c.descs = append(c.descs, prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, spec.Subsystem, m.Name),
+			m.Help,
+			labels,
+			nil,
+		))
+	}
+	return c, nil
+}
+
+// Describe sends the Desc of every metric declared for the class.
+func (c *classCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range c.descs {
+		ch <- d
+	}
+}
+
+// Collect queries all rows of the class and sends their metrics.
+func (c *classCollector) Collect(ch chan<- prometheus.Metric) error {
+	rows, err := c.query()
+	if err != nil {
+		return fmt.Errorf("querying %s: %s", c.spec.Class, err)
+	}
+
+	c.collectRows(ch, rows)
+	return nil
+}
+
+// query returns all rows of the class, in a slice of the row struct.
+func (c *classCollector) query() (reflect.Value, error) {
+	dst := reflect.New(reflect.SliceOf(c.row))
+	q := createQuery(dst.Interface(), c.spec.Class, c.spec.Where)
+	err := runQuery(c.querier, c.logger, q, dst.Interface(), c.spec.Namespace)
+	return dst.Elem(), err
+}
+
+// collectRows sends the metrics of every element of rows, a slice of the row
+// struct. The _Total instance is skipped for classes declaring it, and so are
+// the rows of filtered out VMs.
+func (c *classCollector) collectRows(ch chan<- prometheus.Metric, rows reflect.Value) {
+	for i := 0; i < rows.Len(); i++ {
+		row := rows.Index(i)
+		if c.spec.SkipTotal && row.FieldByName("Name").String() == "_Total" {
+			continue
+		}
+
+		labels := make([]string, len(c.spec.Labels))
+		for j, l := range c.spec.Labels {
+			labels[j] = row.FieldByName(l.Field).String()
+			if l.Value != nil {
+				labels[j] = l.Value(labels[j])
+			}
+		}
+		if !c.matchVM(labels) {
+			continue
+		}
+
+		for j, m := range c.spec.Metrics {
+			ch <- prometheus.MustNewConstMetric(
+				c.descs[j],
+				m.Type,
+				fieldValue(row.FieldByName(m.Field))*m.Scale,
+				labels...,
+			)
+		}
+	}
+}
+
+// matchVM reports whether a row with the given label values passes the VM
+// filters. Rows of classes without a VM label always pass.
+func (c *classCollector) matchVM(labels []string) bool {
+	for j, l := range c.spec.Labels {
+		if !l.VM {
+			continue
+		}
+		if c.vmInclude != nil && !c.vmInclude.MatchString(labels[j]) {
+			return false
+		}
+		if c.vmExclude != nil && c.vmExclude.MatchString(labels[j]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumeric(k reflect.Kind) bool {
+	switch k {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// fieldValue converts a numeric struct field to a float64. Fields of any
+// other kind are reported as 0.
+func fieldValue(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	}
+	return 0
+}
@@ -0,0 +1,141 @@
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+)
+
+// Msvm_VirtualSystemSettingData holds the settings of a VM, including the
+// free-form notes set in Hyper-V Manager.
+type Msvm_VirtualSystemSettingData struct {
+	ElementName string
+	Notes       []string
+}
+
+// VMLabelsCollector exposes the key=value pairs found in the notes of the VMs
+// as labels of the hyperV_vm_labels info metric, so that VM metrics can be
+// joined to them in PromQL.
+type VMLabelsCollector struct {
+	keys    []string
+	desc    *prometheus.Desc
+	querier Querier
+	logger  log.Logger
+
+	vmInclude, vmExclude *regexp.Regexp
+}
+
+// NewVMLabelsCollector builds a collector exposing the notes keys as labels.
+// Characters of the keys that are not valid in label names are replaced by
+// underscores. VMs are filtered like in the per-VM collectors.
+func NewVMLabelsCollector(keys []string, config Config) (*VMLabelsCollector, error) {
+	labels := []string{"vm"}
+	seen := map[string]string{"vm": "the VM name"}
+	for _, key := range keys {
+		name := labelName(key)
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return nil, fmt.Errorf("notes key %q is not a valid label name", key)
+		}
+		if other, ok := seen[name]; ok {
+			return nil, fmt.Errorf("notes key %q has the same label name as %s", key, other)
+		}
+		seen[name] = fmt.Sprintf("notes key %q", key)
+		labels = append(labels, name)
+	}
+
+	return &VMLabelsCollector{
+		keys: keys,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "vm", "labels"),
+			"Labels set with key=value pairs in the notes of the VM, always 1",
+			labels,
+			nil,
+		),
+		querier:   config.classQuerier("Msvm_VirtualSystemSettingData"),
+		logger:    config.logger().With("collector", "vm_labels").With("class", "Msvm_VirtualSystemSettingData"),
+		vmInclude: config.VMInclude,
+		vmExclude: config.VMExclude,
+	}, nil
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+func labelName(key string) string {
+	return invalidLabelChars.ReplaceAllString(key, "_")
+}
+
+// Describe sends the descriptor of hyperV_vm_labels.
+func (c *VMLabelsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect reads the notes of every VM, leaving out snapshots.
+func (c *VMLabelsCollector) Collect(ch chan<- prometheus.Metric) error {
+	rows, err := c.query()
+	if err != nil {
+		return fmt.Errorf("querying Msvm_VirtualSystemSettingData: %s", err)
+	}
+
+	c.collectRows(ch, rows)
+	return nil
+}
+
+func (c *VMLabelsCollector) query() ([]Msvm_VirtualSystemSettingData, error) {
+	var dst []Msvm_VirtualSystemSettingData
+	q := createQuery(&dst, "Msvm_VirtualSystemSettingData", "WHERE VirtualSystemType = 'Microsoft:Hyper-V:System:Realized'")
+	err := runQuery(c.querier, c.logger, q, &dst, `root\virtualization\v2`)
+	return dst, err
+}
+
+func (c *VMLabelsCollector) collectRows(ch chan<- prometheus.Metric, rows []Msvm_VirtualSystemSettingData) {
+	// VM names are not unique in Hyper-V; only the first VM of a name is
+	// exposed, as the others would have the same vm label.
+	seen := map[string]bool{}
+	for _, row := range rows {
+		vm := row.ElementName
+		if seen[vm] {
+			continue
+		}
+		seen[vm] = true
+		if c.vmInclude != nil && !c.vmInclude.MatchString(vm) {
+			continue
+		}
+		if c.vmExclude != nil && c.vmExclude.MatchString(vm) {
+			continue
+		}
+
+		pairs := parseNotes(row.Notes)
+		labels := []string{vm}
+		for _, key := range c.keys {
+			labels = append(labels, pairs[key])
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1, labels...)
+	}
+}
+
+// parseNotes extracts the key=value pairs from the lines of the notes of a
+// VM. Pairs are separated by whitespace, commas or semicolons, so values
+// cannot contain any of them. Other words are ignored, and the first value
+// of a key wins.
+func parseNotes(notes []string) map[string]string {
+	pairs := map[string]string{}
+	for _, line := range notes {
+		words := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ';' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+		})
+		for _, word := range words {
+			i := strings.Index(word, "=")
+			if i <= 0 {
+				continue
+			}
+			if _, ok := pairs[word[:i]]; !ok {
+				pairs[word[:i]] = word[i+1:]
+			}
+		}
+	}
+	return pairs
+}
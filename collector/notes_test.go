@@ -0,0 +1,76 @@
+package collector
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseNotes(t *testing.T) {
+	got := parseNotes([]string{
+		"Web frontend, team=payments;owner=alice",
+		"cost-center=42 team=other broken= =x",
+	})
+	want := map[string]string{
+		"team":        "payments",
+		"owner":       "alice",
+		"cost-center": "42",
+		"broken":      "",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// vmLabelsRows adapts VMLabelsCollector to prometheus.Collector with fixed
+// rows instead of a WMI query.
+type vmLabelsRows struct {
+	*VMLabelsCollector
+	rows []Msvm_VirtualSystemSettingData
+}
+
+func (c vmLabelsRows) Collect(ch chan<- prometheus.Metric) {
+	c.collectRows(ch, c.rows)
+}
+
+func TestVMLabelsCollector(t *testing.T) {
+	c, err := NewVMLabelsCollector([]string{"team", "cost-center"}, Config{
+		VMExclude: regexp.MustCompile("^vdi-"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := []Msvm_VirtualSystemSettingData{
+		{ElementName: "web01", Notes: []string{"team=payments cost-center=42"}},
+		{ElementName: "db01"},
+		{ElementName: "web01", Notes: []string{"team=duplicate"}},
+		{ElementName: "vdi-01", Notes: []string{"team=desktops"}},
+	}
+	want := `
+# HELP hyperV_vm_labels Labels set with key=value pairs in the notes of the VM, always 1
+# TYPE hyperV_vm_labels gauge
+hyperV_vm_labels{cost_center="",team="",vm="db01"} 1
+hyperV_vm_labels{cost_center="42",team="payments",vm="web01"} 1
+`
+	if err := testutil.CollectAndCompare(vmLabelsRows{c, rows}, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVMLabelsKeyValidation(t *testing.T) {
+	for name, keys := range map[string][]string{
+		"vm label":       {"vm"},
+		"same label":     {"cost-center", "cost_center"},
+		"reserved label": {"__name__"},
+		"empty key":      {""},
+	} {
+		if _, err := NewVMLabelsCollector(keys, Config{}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// wbemConn is a connection to a WMI namespace.
+type wbemConn interface {
+	Query(query string, dst interface{}) error
+	Close() error
+}
+
+const (
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = 2 * time.Minute
+)
+
+// Pool keeps a connection to each WMI namespace of the local host it is
+// queried in, and reconnects in the background when a connection breaks,
+// e.g. after the WMI service restarted. Attempts to reconnect are spaced out
+// with an exponential backoff, and queries of a broken namespace fail
+// meanwhile instead of each waiting for WMI. It implements Querier, and
+// prometheus.Collector to expose the state of the connections.
+type Pool struct {
+	dial              func(namespace string) (wbemConn, error)
+	isConnectionError func(error) bool
+	now               func() time.Time
+
+	mtx   sync.Mutex
+	conns map[string]*poolConn
+}
+
+// poolConn is the state of the connection to a namespace.
+type poolConn struct {
+	conn wbemConn
+	// failures counts the failed attempts since the last connection.
+	failures    int
+	nextAttempt time.Time
+
+	connects, failuresTotal float64
+}
+
+// NewPool returns a pool connecting to namespaces, as well as to any other
+// namespace it is queried in. Namespaces that cannot be connected to are
+// retried later.
+func NewPool(namespaces []string) *Pool {
+	p := &Pool{
+		dial:              dialNamespace,
+		isConnectionError: isConnectionError,
+		now:               time.Now,
+		conns:             map[string]*poolConn{},
+	}
+	for _, ns := range namespaces {
+		p.get(ns)
+	}
+	return p
+}
+
+// Query implements Querier. Queries fail without being sent while a broken
+// namespace waits to be reconnected.
+func (p *Pool) Query(query string, dst interface{}, namespace string) error {
+	if namespace == "" {
+		namespace = `root\cimv2`
+	}
+	conn, err := p.get(namespace)
+	if err != nil {
+		return err
+	}
+	err = conn.Query(query, dst)
+	if err != nil && p.isConnectionError(err) {
+		p.broken(namespace, conn)
+	}
+	return err
+}
+
+// get returns the connection to namespace, connecting first if needed and
+// the backoff allows it.
+func (p *Pool) get(namespace string) (wbemConn, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	c, ok := p.conns[namespace]
+	if !ok {
+		c = &poolConn{}
+		p.conns[namespace] = c
+	}
+	if c.conn != nil {
+		return c.conn, nil
+	}
+	if now := p.now(); now.Before(c.nextAttempt) {
+		return nil, notConnectedError(fmt.Sprintf("not connected to WMI namespace %s, reconnecting in %s", namespace, c.nextAttempt.Sub(now).Round(time.Second)))
+	}
+
+	conn, err := p.dial(namespace)
+	if err != nil {
+		c.failures++
+		c.failuresTotal++
+		c.nextAttempt = p.now().Add(reconnectBackoff(c.failures))
+		return nil, notConnectedError(fmt.Sprintf("connecting to WMI namespace %s: %s", namespace, err))
+	}
+	c.conn, c.failures, c.nextAttempt = conn, 0, time.Time{}
+	c.connects++
+	return conn, nil
+}
+
+// notConnectedError is returned for queries in a namespace that could not be
+// connected to.
+type notConnectedError string
+
+func (e notConnectedError) Error() string {
+	return string(e)
+}
+
+// broken closes conn, unless it was already replaced. The namespace is
+// reconnected on the next query or health check.
+func (p *Pool) broken(namespace string, conn wbemConn) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if c := p.conns[namespace]; c.conn == conn {
+		c.conn = nil
+		conn.Close()
+	}
+}
+
+func reconnectBackoff(failures int) time.Duration {
+	backoff := minReconnectBackoff
+	for i := 1; i < failures && backoff < maxReconnectBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxReconnectBackoff {
+		backoff = maxReconnectBackoff
+	}
+	return backoff
+}
+
+// healthCheckQuery is cheap and valid in every namespace.
+const healthCheckQuery = "SELECT Name FROM __NAMESPACE"
+
+type healthCheckRow struct {
+	Name string
+}
+
+// Check runs a query on every connected namespace to detect broken
+// connections, and reconnects the namespaces whose backoff has elapsed.
+func (p *Pool) Check() {
+	p.mtx.Lock()
+	namespaces := make([]string, 0, len(p.conns))
+	for ns := range p.conns {
+		namespaces = append(namespaces, ns)
+	}
+	p.mtx.Unlock()
+
+	for _, ns := range namespaces {
+		var dst []healthCheckRow
+		// Errors are reflected in the connection state.
+		p.Query(healthCheckQuery, &dst, ns)
+	}
+}
+
+// Run checks the connections every interval until stop is closed.
+func (p *Pool) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.Check()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Close closes all connections.
+func (p *Pool) Close() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for _, c := range p.conns {
+		if c.conn != nil {
+			c.conn.Close()
+			c.conn = nil
+		}
+	}
+}
+
+var (
+	poolUpDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "exporter", "wmi_connection_up"),
+		"hyperV_exporter: Whether the WMI namespace is connected.",
+		[]string{"namespace"},
+		nil,
+	)
+	poolConnectsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "exporter", "wmi_connects_total"),
+		"hyperV_exporter: Number of successful connections to the WMI namespace, including reconnections.",
+		[]string{"namespace"},
+		nil,
+	)
+	poolFailuresDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "exporter", "wmi_connect_failures_total"),
+		"hyperV_exporter: Number of failed attempts to connect to the WMI namespace.",
+		[]string{"namespace"},
+		nil,
+	)
+)
+
+// Describe implements prometheus.Collector.
+func (p *Pool) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolUpDesc
+	ch <- poolConnectsDesc
+	ch <- poolFailuresDesc
+}
+
+// Collect implements prometheus.Collector.
+func (p *Pool) Collect(ch chan<- prometheus.Metric) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	namespaces := make([]string, 0, len(p.conns))
+	for ns := range p.conns {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	for _, ns := range namespaces {
+		c := p.conns[ns]
+		var up float64
+		if c.conn != nil {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(poolUpDesc, prometheus.GaugeValue, up, ns)
+		ch <- prometheus.MustNewConstMetric(poolConnectsDesc, prometheus.CounterValue, c.connects, ns)
+		ch <- prometheus.MustNewConstMetric(poolFailuresDesc, prometheus.CounterValue, c.failuresTotal, ns)
+	}
+}
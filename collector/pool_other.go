@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package collector
+
+import (
+	"errors"
+)
+
+func dialNamespace(namespace string) (wbemConn, error) {
+	return nil, errors.New("WMI is only supported on Windows")
+}
+
+func isConnectionError(err error) bool {
+	return false
+}
+
+func hresult(err error) (uint32, bool) {
+	return 0, false
+}
@@ -0,0 +1,145 @@
+package collector
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var errDisconnected = errors.New("disconnected")
+
+// fakeConn fails its queries with err.
+type fakeConn struct {
+	err    error
+	closed bool
+}
+
+func (c *fakeConn) Query(query string, dst interface{}) error { return c.err }
+func (c *fakeConn) Close() error                              { c.closed = true; return nil }
+
+// fakeDialer hands out fakeConns, failing while down is set.
+type fakeDialer struct {
+	down  bool
+	dials int
+	conns []*fakeConn
+}
+
+func (d *fakeDialer) dial(namespace string) (wbemConn, error) {
+	d.dials++
+	if d.down {
+		return nil, errors.New("WMI is down")
+	}
+	c := &fakeConn{}
+	d.conns = append(d.conns, c)
+	return c, nil
+}
+
+func newTestPool(d *fakeDialer, now *time.Time) *Pool {
+	return &Pool{
+		dial:              d.dial,
+		isConnectionError: func(err error) bool { return err == errDisconnected },
+		now:               func() time.Time { return *now },
+		conns:             map[string]*poolConn{},
+	}
+}
+
+func TestPoolReconnects(t *testing.T) {
+	now := time.Unix(1000, 0)
+	d := &fakeDialer{}
+	p := newTestPool(d, &now)
+
+	var dst []healthCheckRow
+	if err := p.Query("SELECT Name FROM Win32_A", &dst, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Query("SELECT Name FROM Win32_A", &dst, `root\cimv2`); err != nil {
+		t.Fatal(err)
+	}
+	if d.dials != 1 {
+		t.Fatalf("got %d connections, want the first one to be reused", d.dials)
+	}
+
+	// A query error keeps the connection.
+	d.conns[0].err = errors.New("invalid class")
+	p.Query("SELECT Name FROM Win32_A", &dst, "")
+	if d.conns[0].closed {
+		t.Fatal("connection closed after a query error")
+	}
+
+	// A connection error closes it, and the next query reconnects.
+	d.conns[0].err = errDisconnected
+	p.Query("SELECT Name FROM Win32_A", &dst, "")
+	if !d.conns[0].closed {
+		t.Fatal("broken connection not closed")
+	}
+	d.down = true
+	for i, backoff := range []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second} {
+		now = now.Add(backoff)
+		if err := p.Query("SELECT Name FROM Win32_A", &dst, ""); err == nil || !strings.Contains(err.Error(), "connecting") {
+			t.Errorf("attempt %d: expected a connection error, got %v", i, err)
+		}
+		// Queries during the backoff don't try to connect.
+		if err := p.Query("SELECT Name FROM Win32_A", &dst, ""); err == nil || !strings.Contains(err.Error(), "reconnecting in") {
+			t.Errorf("attempt %d: expected a backoff error, got %v", i, err)
+		}
+	}
+	if d.dials != 5 {
+		t.Errorf("got %d connection attempts, want 5", d.dials)
+	}
+
+	d.down = false
+	now = now.Add(8 * time.Second)
+	if err := p.Query("SELECT Name FROM Win32_A", &dst, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `
+# HELP hyperV_exporter_wmi_connect_failures_total hyperV_exporter: Number of failed attempts to connect to the WMI namespace.
+# TYPE hyperV_exporter_wmi_connect_failures_total counter
+hyperV_exporter_wmi_connect_failures_total{namespace="root\\cimv2"} 4
+# HELP hyperV_exporter_wmi_connection_up hyperV_exporter: Whether the WMI namespace is connected.
+# TYPE hyperV_exporter_wmi_connection_up gauge
+hyperV_exporter_wmi_connection_up{namespace="root\\cimv2"} 1
+# HELP hyperV_exporter_wmi_connects_total hyperV_exporter: Number of successful connections to the WMI namespace, including reconnections.
+# TYPE hyperV_exporter_wmi_connects_total counter
+hyperV_exporter_wmi_connects_total{namespace="root\\cimv2"} 2
+`
+	if err := testutil.CollectAndCompare(p, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPoolCheck(t *testing.T) {
+	now := time.Unix(1000, 0)
+	d := &fakeDialer{}
+	p := newTestPool(d, &now)
+	p.get(`root\cimv2`)
+	p.get(`root\virtualization\v2`)
+
+	d.conns[1].err = errDisconnected
+	p.Check()
+	if d.conns[0].closed || !d.conns[1].closed {
+		t.Fatal("the health check should only close the broken connection")
+	}
+	p.Check()
+	if d.dials != 3 {
+		t.Errorf("got %d connections, want the broken one to be reconnected", d.dials)
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	for failures, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		5:  16 * time.Second,
+		8:  maxReconnectBackoff,
+		50: maxReconnectBackoff,
+	} {
+		if got := reconnectBackoff(failures); got != want {
+			t.Errorf("%d failures: got %s, want %s", failures, got, want)
+		}
+	}
+}
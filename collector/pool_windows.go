@@ -0,0 +1,81 @@
+package collector
+
+import (
+	"github.com/go-ole/go-ole"
+)
+
+// swbemConn is a connection to a namespace: the SWbemServices object
+// returned by ConnectServer, kept on its COM thread and used for every query
+// until the connection breaks.
+type swbemConn struct {
+	thread   *comThread
+	services *ole.IDispatch
+}
+
+func dialNamespace(namespace string) (wbemConn, error) {
+	thread, err := newCOMThread()
+	if err != nil {
+		return nil, err
+	}
+	conn := &swbemConn{thread: thread}
+	err = thread.do(func() (err error) {
+		conn.services, err = connectServer(nil, namespace)
+		return err
+	})
+	if err != nil {
+		thread.close()
+		return nil, err
+	}
+	// Query once, so that a missing namespace is reported right away.
+	var dst []healthCheckRow
+	if err := conn.Query(healthCheckQuery, &dst); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *swbemConn) Query(query string, dst interface{}) error {
+	return c.thread.do(func() error {
+		return execQuery(c.services, query, dst)
+	})
+}
+
+func (c *swbemConn) Close() error {
+	err := c.thread.do(func() error {
+		c.services.Release()
+		return nil
+	})
+	c.thread.close()
+	return err
+}
+
+// connectionErrors are the HRESULTs telling that WMI is not reachable
+// anymore, as opposed to errors in a query.
+var connectionErrors = map[uint32]bool{
+	0x800706BA: true, // RPC_S_SERVER_UNAVAILABLE
+	0x800706BE: true, // RPC_S_CALL_FAILED
+	0x80010108: true, // RPC_E_DISCONNECTED
+	0x80041014: true, // WBEM_E_INITIALIZATION_FAILURE
+	0x80041015: true, // WBEM_E_TRANSPORT_FAILURE
+	0x80041033: true, // WBEM_E_SHUTTING_DOWN
+}
+
+func isConnectionError(err error) bool {
+	code, ok := hresult(err)
+	return ok && connectionErrors[code]
+}
+
+// hresult returns the HRESULT of an error of a COM call.
+func hresult(err error) (uint32, bool) {
+	oleErr, ok := err.(*ole.OleError)
+	if !ok {
+		return 0, false
+	}
+	code := uint32(oleErr.Code())
+	// Errors raised by WMI come as exceptions holding the actual code.
+	if exc, ok := oleErr.SubError().(ole.EXCEPINFO); ok {
+		code = exc.SCODE()
+	}
+	return code, true
+}
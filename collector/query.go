@@ -0,0 +1,37 @@
+package collector
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/prometheus/common/log"
+)
+
+// Querier runs WQL queries. Query fills dst, a pointer to a slice of structs,
+// like wmi.Query. An empty namespace stands for root\cimv2.
+type Querier interface {
+	Query(query string, dst interface{}, namespace string) error
+}
+
+// WMIQuerier queries WMI through the COM API of Windows, on the local host or,
+// when Host is set, on a remote host over DCOM. Username and Password are
+// optional; the credentials of the exporter are used when they are empty.
+type WMIQuerier struct {
+	Host     string
+	Username string
+	Password string
+}
+
+// runQuery runs query with querier and logs it at debug level, with its
+// duration and the number of rows returned.
+func runQuery(querier Querier, logger log.Logger, query string, dst interface{}, namespace string) error {
+	begin := time.Now()
+	err := querier.Query(query, dst, namespace)
+	logger = logger.With("query", query).With("duration_seconds", time.Since(begin).Seconds())
+	if err != nil {
+		logger.Debugf("WMI query failed: %s", err)
+		return err
+	}
+	logger.With("rows", reflect.Indirect(reflect.ValueOf(dst)).Len()).Debug("WMI query succeeded")
+	return nil
+}
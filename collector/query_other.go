@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package collector
+
+import (
+	"errors"
+)
+
+// Query implements Querier. WMI is only available on Windows.
+func (q WMIQuerier) Query(query string, dst interface{}, namespace string) error {
+	return errors.New("WMI queries are only supported on Windows")
+}
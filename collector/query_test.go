@@ -0,0 +1,72 @@
+package collector
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// rowsQuerier returns rows, or fails with err.
+type rowsQuerier struct {
+	rows int
+	err  error
+}
+
+func (q rowsQuerier) Query(query string, dst interface{}, namespace string) error {
+	if q.err != nil {
+		return q.err
+	}
+	v := reflect.ValueOf(dst).Elem()
+	v.Set(reflect.MakeSlice(v.Type(), q.rows, q.rows))
+	return nil
+}
+
+func TestQueriesAreLogged(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.NewLogger(&buf)
+	if err := logger.SetLevel("debug"); err != nil {
+		t.Fatal(err)
+	}
+
+	collectors, err := NewCollectors([]string{"vid"}, Config{Querier: rowsQuerier{rows: 3}, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan prometheus.Metric, 100)
+	if err := collectors["vid"].Collect(ch); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"collector=vid",
+		"class=Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition",
+		`query="SELECT `,
+		"rows=3",
+		"duration_seconds=",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log %q does not contain %s", buf.String(), want)
+		}
+	}
+
+	// Errors are returned with the class, and only logged at debug level.
+	if err := logger.SetLevel("info"); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	collectors, err = NewCollectors([]string{"vid"}, Config{Querier: rowsQuerier{err: errors.New("access denied")}, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = collectors["vid"].Collect(ch)
+	if err == nil || !strings.Contains(err.Error(), "Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("unexpected log %q", buf.String())
+	}
+}
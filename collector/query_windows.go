@@ -0,0 +1,43 @@
+package collector
+
+import (
+	"sync"
+)
+
+var (
+	queryThreadOnce sync.Once
+	queryThread     *comThread
+	queryThreadErr  error
+)
+
+// Query implements Querier. Every query connects to the namespace, as the
+// host and credentials vary, on a COM thread shared by all WMIQueriers:
+// initializing COM for each query leaks memory with WMF 5 and later, see
+// https://github.com/martinlindhe/wmi_exporter/issues/77.
+func (q WMIQuerier) Query(query string, dst interface{}, namespace string) error {
+	queryThreadOnce.Do(func() {
+		queryThread, queryThreadErr = newCOMThread()
+	})
+	if queryThreadErr != nil {
+		return queryThreadErr
+	}
+
+	var connectServerArgs []interface{}
+	switch {
+	case q.Host != "":
+		if namespace == "" {
+			namespace = `root\cimv2`
+		}
+		connectServerArgs = []interface{}{q.Host, namespace, q.Username, q.Password}
+	case namespace != "":
+		connectServerArgs = []interface{}{nil, namespace}
+	}
+	return queryThread.do(func() error {
+		services, err := connectServer(connectServerArgs...)
+		if err != nil {
+			return err
+		}
+		defer services.Release()
+		return execQuery(services, query, dst)
+	})
+}
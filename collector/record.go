@@ -0,0 +1,129 @@
+package collector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/prometheus/common/log"
+)
+
+// recording is the content of the file of a recorded query.
+type recording struct {
+	Namespace string          `json:"namespace"`
+	Query     string          `json:"query"`
+	Rows      json.RawMessage `json:"rows,omitempty"`
+	// Error is the message of the error the query failed with.
+	Error string `json:"error,omitempty"`
+}
+
+var fromClass = regexp.MustCompile(`(?i)\bFROM\s+(\w+)`)
+
+// recordingPath returns the path of the recording of query in dir, named
+// after the class queried and a hash of the query, so that queries of a
+// class with different conditions are kept apart.
+func recordingPath(dir, query, namespace string) string {
+	if namespace == "" {
+		namespace = `root\cimv2`
+	}
+	class := "query"
+	if m := fromClass.FindStringSubmatch(query); m != nil {
+		class = m[1]
+	}
+	sum := sha256.Sum256([]byte(namespace + "\n" + query))
+	return filepath.Join(dir, fmt.Sprintf("%s-%s.json", class, hex.EncodeToString(sum[:4])))
+}
+
+// RecordingQuerier runs the queries with Querier and saves their results, rows
+// or error, as JSON files in Dir, for ReplayQuerier to serve them later. The
+// file of a query is overwritten every time it runs. Failing to record a
+// query is logged to Logger, log.Base() if nil, and does not fail the query.
+type RecordingQuerier struct {
+	Querier Querier
+	Dir     string
+	Logger  log.Logger
+}
+
+// Query implements Querier.
+func (q RecordingQuerier) Query(query string, dst interface{}, namespace string) error {
+	err := q.Querier.Query(query, dst, namespace)
+	if rerr := q.record(query, dst, namespace, err); rerr != nil {
+		logger := q.Logger
+		if logger == nil {
+			logger = log.Base()
+		}
+		logger.With("query", query).Warnf("Couldn't record the query: %s", rerr)
+	}
+	return err
+}
+
+func (q RecordingQuerier) record(query string, dst interface{}, namespace string, queryErr error) error {
+	r := recording{Namespace: namespace, Query: query}
+	if queryErr != nil {
+		r.Error = queryErr.Error()
+	} else {
+		rows, err := json.Marshal(dst)
+		if err != nil {
+			return err
+		}
+		r.Rows = rows
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Concurrent scrapes record the same queries: the file is written aside
+	// and renamed, so that it is never read half written.
+	f, err := ioutil.TempFile(q.Dir, ".recording-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), recordingPath(q.Dir, query, namespace))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// ReplayQuerier serves the results saved in Dir by a RecordingQuerier, e.g.
+// to reproduce on any platform what a collector saw on a Windows host.
+// Queries that were not recorded fail.
+type ReplayQuerier struct {
+	Dir string
+}
+
+// Query implements Querier.
+func (q ReplayQuerier) Query(query string, dst interface{}, namespace string) error {
+	path := recordingPath(q.Dir, query, namespace)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no recording of %s in %s", query, q.Dir)
+	}
+	if err != nil {
+		return err
+	}
+	var r recording
+	if err := json.Unmarshal(b, &r); err != nil {
+		return fmt.Errorf("invalid recording %s: %s", path, err)
+	}
+	if r.Error != "" {
+		return errors.New(r.Error)
+	}
+	if err := json.Unmarshal(r.Rows, dst); err != nil {
+		return fmt.Errorf("invalid recording %s: %s", path, err)
+	}
+	return nil
+}
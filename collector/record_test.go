@@ -0,0 +1,149 @@
+package collector
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/common/log"
+)
+
+type recordedRow struct {
+	Name  string
+	Value uint64
+}
+
+// fixedQuerier returns rows, or fails with err.
+type fixedQuerier struct {
+	rows []recordedRow
+	err  error
+}
+
+func (q fixedQuerier) Query(query string, dst interface{}, namespace string) error {
+	if q.err != nil {
+		return q.err
+	}
+	*dst.(*[]recordedRow) = q.rows
+	return nil
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rows := []recordedRow{{Name: "vm1", Value: 1}, {Name: "vm2", Value: 2}}
+	record := RecordingQuerier{Querier: fixedQuerier{rows: rows}, Dir: dir}
+	var got []recordedRow
+	if err := record.Query("SELECT * FROM Win32_Foo", &got, ""); err != nil {
+		t.Fatal(err)
+	}
+	record.Querier = fixedQuerier{err: errors.New("access denied")}
+	if err := record.Query("SELECT * FROM Win32_Bar", &got, `root\virtualization\v2`); err == nil || err.Error() != "access denied" {
+		t.Fatalf("got error %v when recording a failed query, want access denied", err)
+	}
+
+	// Only the recordings are left in the directory.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("got %d files in the directory, want 2 recordings", len(files))
+	}
+
+	replay := ReplayQuerier{Dir: dir}
+	got = nil
+	if err := replay.Query("SELECT * FROM Win32_Foo", &got, `root\cimv2`); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, rows) {
+		t.Errorf("replayed %v, want %v", got, rows)
+	}
+	if err := replay.Query("SELECT * FROM Win32_Bar", &got, `root\virtualization\v2`); err == nil || err.Error() != "access denied" {
+		t.Errorf("got error %v when replaying a failed query, want access denied", err)
+	}
+	// The recordings are per namespace and query.
+	for _, q := range []struct{ query, namespace string }{
+		{"SELECT * FROM Win32_Bar", ""},
+		{"SELECT Name FROM Win32_Foo", ""},
+	} {
+		if err := replay.Query(q.query, &got, q.namespace); err == nil || !strings.Contains(err.Error(), "no recording") {
+			t.Errorf("got error %v when replaying %q in %q, want no recording", err, q.query, q.namespace)
+		}
+	}
+}
+
+func TestConcurrentRecordings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rows := make([]recordedRow, 20000)
+	record := RecordingQuerier{Querier: fixedQuerier{rows: rows}, Dir: dir}
+	replay := ReplayQuerier{Dir: dir}
+	var got []recordedRow
+	if err := record.Query("SELECT * FROM Win32_Foo", &got, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				var got []recordedRow
+				record.Query("SELECT * FROM Win32_Foo", &got, "")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				var got []recordedRow
+				if err := replay.Query("SELECT * FROM Win32_Foo", &got, ""); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRecordingFailureKeepsResult(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	rows := []recordedRow{{Name: "vm1", Value: 1}}
+	// The directory does not exist, so the recordings cannot be written.
+	record := RecordingQuerier{Querier: fixedQuerier{rows: rows}, Dir: filepath.Join(dir, "missing"), Logger: log.NewLogger(&buf)}
+	var got []recordedRow
+	if err := record.Query("SELECT * FROM Win32_Foo", &got, ""); err != nil {
+		t.Fatalf("got error %v, want the rows of the querier", err)
+	}
+	if !reflect.DeepEqual(got, rows) {
+		t.Errorf("got %v, want %v", got, rows)
+	}
+	record.Querier = fixedQuerier{err: errors.New("access denied")}
+	if err := record.Query("SELECT * FROM Win32_Bar", &got, ""); err == nil || err.Error() != "access denied" {
+		t.Errorf("got error %v, want access denied", err)
+	}
+	if n := strings.Count(buf.String(), "level=warning"); n != 2 {
+		t.Errorf("got %d warnings, want 2:\n%s", n, buf.String())
+	}
+}
@@ -18,6 +18,9 @@ const (
 
 // Collector is the interface a collector has to implement.
 type Collector interface {
+	// Describe sends the descriptors of all metrics the collector can expose.
+	Describe(ch chan<- *prometheus.Desc)
+
 	// Get new metrics and expose them via prometheus registry.
 	Collect(ch chan<- prometheus.Metric) (err error)
 }
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/iyacontrol/HyperV-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// WmiCollector implements the prometheus.Collector interface.
+type WmiCollector struct {
+	collectors map[string]collector.Collector
+	timeout    time.Duration
+	// status, if set, records the outcome of every collection.
+	status *statusTracker
+	logger log.Logger
+	// metrics, if set, counts the collections in flight.
+	metrics *collector.QueryMetrics
+}
+
+var (
+	scrapeDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "collector_duration_seconds"),
+		"hyperV_exporter: Duration of a collection.",
+		[]string{"collector"},
+		nil,
+	)
+	scrapeSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(collector.Namespace, "exporter", "collector_success"),
+		"hyperV_exporter: Whether the collector was successful.",
+		[]string{"collector"},
+		nil,
+	)
+)
+
+// Describe sends all the descriptors of the collectors included to
+// the provided channel.
+func (coll WmiCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- scrapeDurationDesc
+	ch <- scrapeSuccessDesc
+	for _, c := range coll.collectors {
+		c.Describe(ch)
+	}
+}
+
+// Collect sends the collected metrics from each of the collectors to
+// prometheus. Collectors run concurrently; those that have not finished
+// when the timeout expires are reported as failed and their metrics are
+// dropped.
+func (coll WmiCollector) Collect(ch chan<- prometheus.Metric) {
+	defer coll.metrics.ScrapeStarted()()
+
+	// Buffered so that collectors finishing after the timeout do not block.
+	results := make(chan collectorResult, len(coll.collectors))
+	pending := map[string]bool{}
+	for name, c := range coll.collectors {
+		pending[name] = true
+		go func(name string, c collector.Collector) {
+			results <- execute(name, c, coll.logger)
+		}(name, c)
+	}
+
+	var timeout <-chan time.Time
+	if coll.timeout > 0 {
+		timer := time.NewTimer(coll.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for len(pending) > 0 {
+		select {
+		case r := <-results:
+			delete(pending, r.name)
+			coll.status.record(r)
+			r.send(ch)
+		case <-timeout:
+			for name := range pending {
+				coll.logger.With("collector", name).With("duration_seconds", coll.timeout.Seconds()).Error("Collector timed out")
+				r := collectorResult{name: name, duration: coll.timeout, err: errTimeout}
+				coll.status.record(r)
+				r.send(ch)
+			}
+			return
+		}
+	}
+}
+
+var errTimeout = errors.New("collection timed out")
+
+// collectorResult holds the outcome of a single collector run.
+type collectorResult struct {
+	name     string
+	metrics  []prometheus.Metric
+	duration time.Duration
+	err      error
+}
+
+func execute(name string, c collector.Collector, logger log.Logger) collectorResult {
+	metrics := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	r := collectorResult{name: name}
+	go func() {
+		for m := range metrics {
+			r.metrics = append(r.metrics, m)
+		}
+		close(done)
+	}()
+
+	begin := time.Now()
+	err := c.Collect(metrics)
+	close(metrics)
+	<-done
+	r.duration = time.Since(begin)
+	r.err = err
+
+	logger = logger.With("collector", name).With("duration_seconds", r.duration.Seconds())
+	if err != nil {
+		logger.Errorf("Collector failed: %s", err)
+	} else {
+		logger.Debug("Collector succeeded")
+	}
+	return r
+}
+
+// send forwards the collected metrics followed by the scrape metrics of the
+// collector.
+func (r collectorResult) send(ch chan<- prometheus.Metric) {
+	var success float64
+	if r.err == nil {
+		success = 1
+	}
+	for _, m := range r.metrics {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(
+		scrapeDurationDesc,
+		prometheus.GaugeValue,
+		r.duration.Seconds(),
+		r.name,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		scrapeSuccessDesc,
+		prometheus.GaugeValue,
+		success,
+		r.name,
+	)
+}
+
+// loadCollectors builds the enabled collectors, running their queries with
+// querier, recording them in metrics if set, and logging to logger.
+func loadCollectors(config *Config, enabled []string, querier collector.Querier, metrics *collector.QueryMetrics, logger log.Logger) (map[string]collector.Collector, error) {
+	hyperVConfig := collector.Config{
+		LegacyMetricNames: config.Collectors.LegacyMetricNames,
+		Querier:           querier,
+		Metrics:           metrics,
+		Logger:            logger,
+	}
+	var err error
+	if hyperVConfig.VMInclude, err = compileAnchored(config.Collectors.VMInclude); err != nil {
+		return nil, fmt.Errorf("invalid vm_include: %s", err)
+	}
+	if hyperVConfig.VMExclude, err = compileAnchored(config.Collectors.VMExclude); err != nil {
+		return nil, fmt.Errorf("invalid vm_exclude: %s", err)
+	}
+
+	var builtin []string
+	collectors := map[string]collector.Collector{}
+	for _, name := range enabled {
+		var c collector.Collector
+		switch name {
+		case "custom":
+			c, err = collector.NewCustomCollector(config.CustomQueries, hyperVConfig)
+		case "vm_labels":
+			c, err = collector.NewVMLabelsCollector(config.Labels.VMNotes, hyperVConfig)
+		default:
+			builtin = append(builtin, name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		collectors[name] = c
+	}
+
+	c, err := collector.NewCollectors(builtin, hyperVConfig)
+	if err != nil {
+		return nil, err
+	}
+	for name, c := range c {
+		collectors[name] = c
+	}
+	return collectors, nil
+}
+
+// localQuerier returns the querier of the local collectors: q, recording its
+// results to recordDir if set, or the results recorded in replayDir instead.
+func localQuerier(q collector.Querier, recordDir, replayDir string) (collector.Querier, error) {
+	switch {
+	case recordDir != "" && replayDir != "":
+		return nil, errors.New("--wmi.record and --wmi.replay cannot be used together")
+	case recordDir != "":
+		if err := os.MkdirAll(recordDir, 0755); err != nil {
+			return nil, err
+		}
+		return collector.RecordingQuerier{Querier: q, Dir: recordDir}, nil
+	case replayDir != "":
+		if _, err := os.Stat(replayDir); err != nil {
+			return nil, err
+		}
+		return collector.ReplayQuerier{Dir: replayDir}, nil
+	}
+	return q, nil
+}
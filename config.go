@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/iyacontrol/HyperV-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the content of the file passed with --config.file. Settings left
+// out of the file keep the value of the corresponding flag.
+type Config struct {
+	Web        WebConfig        `yaml:"web"`
+	Collectors CollectorsConfig `yaml:"collectors"`
+	Metrics    MetricsConfig    `yaml:"metrics"`
+	Labels     LabelsConfig     `yaml:"labels"`
+	// Modules configure how remote hosts are scraped through /probe, keyed
+	// by the module parameter.
+	Modules map[string]ModuleConfig `yaml:"modules"`
+	// CustomQueries are user-defined WMI queries, exposed by the "custom"
+	// collector.
+	CustomQueries []collector.CustomQuery `yaml:"custom_queries"`
+}
+
+// WebConfig configures the HTTP listener. ListenAddress and MetricsPath are
+// only read at startup; the TLS settings and the users are reloaded.
+type WebConfig struct {
+	ListenAddress string `yaml:"listen_address"`
+	MetricsPath   string `yaml:"metrics_path"`
+	HTTPSConfig   `yaml:",inline"`
+}
+
+// HTTPSConfig secures all the handlers of the exporter. It has the format of
+// the web configuration file of the Prometheus exporter toolkit, which can be
+// passed with --web.config.file instead of setting it in the web section.
+type HTTPSConfig struct {
+	TLSServerConfig TLSServerConfig `yaml:"tls_server_config"`
+	// BasicAuthUsers maps user names to bcrypt hashes of their passwords.
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+}
+
+// TLSServerConfig enables HTTPS when both files are set.
+type TLSServerConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientAuthType is the policy for client certificates, named like
+	// tls.ClientAuthType, e.g. RequireAndVerifyClientCert. Verified
+	// certificates must be signed by a CA of ClientCAFile.
+	ClientAuthType string `yaml:"client_auth_type"`
+	ClientCAFile   string `yaml:"client_ca_file"`
+	// MinVersion defaults to TLS12.
+	MinVersion tlsVersion `yaml:"min_version"`
+	MaxVersion tlsVersion `yaml:"max_version"`
+	// CipherSuites restricts the cipher suites of TLS 1.2 and below.
+	CipherSuites             []cipherSuite `yaml:"cipher_suites"`
+	PreferServerCipherSuites bool          `yaml:"prefer_server_cipher_suites"`
+}
+
+// CollectorsConfig selects and tunes the collectors.
+type CollectorsConfig struct {
+	// Enabled lists the collectors to run. All built-in collectors are
+	// enabled when it is empty, plus "custom" if custom queries are set and
+	// "vm_labels" if notes keys are.
+	Enabled []string `yaml:"enabled"`
+	// Timeout bounds the duration of a collection. Collectors still running
+	// when it expires are reported as failed. Zero disables the timeout.
+	Timeout           model.Duration `yaml:"timeout"`
+	LegacyMetricNames bool           `yaml:"legacy_metric_names"`
+	// VMInclude and VMExclude are regular expressions selecting the VMs
+	// exposed by the per-VM collectors. Like the metric filters they are
+	// anchored and must match the whole name. Empty values disable the
+	// filter.
+	VMInclude string      `yaml:"vm_include"`
+	VMExclude string      `yaml:"vm_exclude"`
+	Cache     CacheConfig `yaml:"cache"`
+	Ready     ReadyConfig `yaml:"ready"`
+}
+
+// ReadyConfig sets when /ready reports the exporter as not ready.
+type ReadyConfig struct {
+	// Core lists the collectors that must be working, all the enabled ones
+	// when empty.
+	Core []string `yaml:"core"`
+	// FailureThreshold is how long a core collector may fail before the
+	// exporter is not ready.
+	FailureThreshold model.Duration `yaml:"failure_threshold"`
+}
+
+// CacheConfig enables caching the collection results for the scrapes of
+// the metrics path.
+type CacheConfig struct {
+	// MaxAge is how long a result is served before it is collected again.
+	// Zero disables the cache.
+	MaxAge model.Duration `yaml:"max_age"`
+	// RefreshInterval, if set, refreshes the cache in the background instead
+	// of during the scrapes. MaxAge defaults to twice the interval.
+	RefreshInterval model.Duration `yaml:"refresh_interval"`
+}
+
+// MetricsConfig filters and relabels the exposed metrics, for Prometheus
+// servers that cannot apply metric_relabel_configs. Like those, the regular
+// expressions are anchored at both ends.
+type MetricsConfig struct {
+	// Allow, if set, only exposes the metrics whose name matches it. Deny
+	// drops the metrics whose name matches it.
+	Allow      string      `yaml:"allow"`
+	Deny       string      `yaml:"deny"`
+	LabelRules []LabelRule `yaml:"label_rules"`
+}
+
+// LabelRule renames or drops a label.
+type LabelRule struct {
+	// Metrics restricts the rule to the metrics whose name matches it.
+	Metrics string `yaml:"metrics"`
+	// Action is either rename or drop.
+	Action      string `yaml:"action"`
+	Label       string `yaml:"label"`
+	TargetLabel string `yaml:"target_label"`
+}
+
+// LabelsConfig adds labels identifying the host and the owners of the VMs.
+type LabelsConfig struct {
+	// Static labels are added to every metric of the exporter.
+	Static prometheus.Labels `yaml:"static"`
+	// VMNotes lists the keys of the key=value pairs in the notes of the VMs
+	// exposed as labels of hyperV_vm_labels by the "vm_labels" collector.
+	VMNotes []string `yaml:"vm_notes"`
+}
+
+// ModuleConfig selects the collectors and credentials used to probe remote
+// hosts.
+type ModuleConfig struct {
+	// Collectors lists the collectors to run against the target, all the
+	// built-in ones when empty.
+	Collectors []string `yaml:"collectors"`
+	// Protocol is wmi (the default) to connect with DCOM, or winrm to use
+	// WS-Management.
+	Protocol string `yaml:"protocol"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// WinRM configures the winrm protocol. Targets without a port are
+	// scraped on 5985, or 5986 with HTTPS.
+	WinRM WinRMConfig `yaml:"winrm"`
+	// Targets, if set, restricts /probe to the targets matching one of its
+	// entries, host names or anchored regular expressions, so that the
+	// credentials of the module are only sent to the hosts they are meant
+	// for.
+	Targets []string `yaml:"targets"`
+
+	targets *regexp.Regexp
+}
+
+// allowsTarget reports whether the module may be used to probe target.
+func (m ModuleConfig) allowsTarget(target string) bool {
+	return m.targets == nil || m.targets.MatchString(target)
+}
+
+// WinRMConfig configures the connections to WinRM.
+type WinRMConfig struct {
+	HTTPS              bool `yaml:"https"`
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+// loadConfig reads the configuration file at path on top of base, which holds
+// the values of the flags, then the web configuration file at webPath if set.
+// Unknown keys are rejected so that typos are reported instead of silently
+// ignored.
+func loadConfig(path, webPath string, base Config) (*Config, error) {
+	config := base
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(b, &config); err != nil {
+			return nil, err
+		}
+	}
+	if webPath != "" {
+		web, err := loadWebConfig(webPath)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load web config file %s: %s", webPath, err)
+		}
+		config.Web.HTTPSConfig = web
+	}
+
+	tls := config.Web.TLSServerConfig
+	if (tls.CertFile == "") != (tls.KeyFile == "") {
+		return nil, fmt.Errorf("tls_server_config needs both cert_file and key_file")
+	}
+	if len(config.Collectors.Enabled) == 0 {
+		config.Collectors.Enabled = collector.Available()
+		if len(config.CustomQueries) > 0 {
+			config.Collectors.Enabled = append(config.Collectors.Enabled, "custom")
+		}
+		if len(config.Labels.VMNotes) > 0 {
+			config.Collectors.Enabled = append(config.Collectors.Enabled, "vm_labels")
+		}
+	}
+	enabled := map[string]bool{}
+	for _, name := range config.Collectors.Enabled {
+		enabled[name] = true
+	}
+	for _, name := range config.Collectors.Ready.Core {
+		if !enabled[name] {
+			return nil, fmt.Errorf("core collector %s is not enabled", name)
+		}
+	}
+
+	if cache := &config.Collectors.Cache; cache.RefreshInterval > 0 {
+		if cache.MaxAge == 0 {
+			cache.MaxAge = 2 * cache.RefreshInterval
+		}
+		if cache.MaxAge < cache.RefreshInterval {
+			return nil, fmt.Errorf("cache max_age must not be shorter than refresh_interval")
+		}
+	}
+
+	if config.Modules == nil {
+		config.Modules = map[string]ModuleConfig{}
+	}
+	if _, ok := config.Modules["default"]; !ok {
+		config.Modules["default"] = ModuleConfig{}
+	}
+	for name, module := range config.Modules {
+		switch module.Protocol {
+		case "":
+			module.Protocol = "wmi"
+		case "wmi", "winrm":
+		default:
+			return nil, fmt.Errorf("module %s: unknown protocol %q", name, module.Protocol)
+		}
+		// Basic authentication would send the credentials in clear to any
+		// target given to /probe, and without verifying the certificate of
+		// the target to whoever answers in its place.
+		if module.Protocol == "winrm" && (module.Username != "" || module.Password != "") {
+			if !module.WinRM.HTTPS {
+				return nil, fmt.Errorf("module %s: winrm credentials need https", name)
+			}
+			if module.WinRM.InsecureSkipVerify {
+				return nil, fmt.Errorf("module %s: winrm credentials cannot be used with insecure_skip_verify", name)
+			}
+		}
+		if len(module.Targets) > 0 {
+			var err error
+			if module.targets, err = compileAnchored("(?:" + strings.Join(module.Targets, ")|(?:") + ")"); err != nil {
+				return nil, fmt.Errorf("module %s: invalid targets: %s", name, err)
+			}
+		}
+		if len(module.Collectors) == 0 {
+			module.Collectors = collector.Available()
+		}
+		config.Modules[name] = module
+	}
+	return &config, nil
+}
@@ -1,148 +1,389 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/StackExchange/wmi"
 	"github.com/iyacontrol/HyperV-exporter/collector"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/version"
-	"golang.org/x/sys/windows/svc"
 )
 
-// WmiCollector implements the prometheus.Collector interface.
-type WmiCollector struct {
-	collector collector.Collector
-}
-
 const (
 	serviceName = "hyperV_exporter"
 )
 
-var (
-	scrapeDurationDesc = prometheus.NewDesc(
-		prometheus.BuildFQName(collector.Namespace, "exporter", "collector_duration_seconds"),
-		"hyperV_exporter: Duration of a collection.",
-		[]string{"collector"},
-		nil,
-	)
-	scrapeSuccessDesc = prometheus.NewDesc(
-		prometheus.BuildFQName(collector.Namespace, "exporter", "collector_success"),
-		"hyperV_exporter: Whether the collector was successful.",
-		[]string{"collector"},
-		nil,
-	)
-)
+// exporter holds the state that is rebuilt when the configuration is
+// reloaded: the configuration itself, the registry behind the metrics
+// handler and the TLS settings.
+type exporter struct {
+	configFile    string
+	webConfigFile string
+	flags         Config
+	// pool connects the local collectors to WMI.
+	pool *collector.Pool
+	// querier runs the queries of the local collectors, through the pool
+	// unless they are recorded or replayed.
+	querier collector.Querier
+	// queryMetrics instruments the queries of the local collectors.
+	queryMetrics *collector.QueryMetrics
+	// status records the outcome of the collections for /ready.
+	status *statusTracker
 
-// Describe sends all the descriptors of the collectors included to
-// the provided channel.
-func (coll WmiCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- scrapeDurationDesc
-	ch <- scrapeSuccessDesc
+	mtx     sync.RWMutex
+	config  *Config
+	handler http.Handler
+	tls     *tls.Config
+	// stopRefresh stops the background refresh of the cache, if enabled.
+	stopRefresh chan struct{}
 }
 
-// Collect sends the collected metrics from each of the collectors to
-// prometheus. Collect could be called several times concurrently
-// and thus its run is protected by a single mutex.
-func (coll WmiCollector) Collect(ch chan<- prometheus.Metric) {
-	execute(coll.collector, ch)
-}
+// reload reads the configuration file and rebuilds the collectors. On error
+// the previous configuration stays in effect.
+func (e *exporter) reload() error {
+	config, err := loadConfig(e.configFile, e.webConfigFile, e.flags)
+	if err != nil {
+		return fmt.Errorf("couldn't load config file %s: %s", e.configFile, err)
+	}
 
-func execute(c collector.Collector, ch chan<- prometheus.Metric) {
-	begin := time.Now()
-	err := c.Collect(ch)
-	duration := time.Since(begin)
-	var success float64
+	collectors, err := loadCollectors(config, config.Collectors.Enabled, e.querier, e.queryMetrics, log.Base())
+	if err != nil {
+		return fmt.Errorf("couldn't load collectors: %s", err)
+	}
 
+	var wmiCollector prometheus.Collector = WmiCollector{
+		collectors: collectors,
+		timeout:    time.Duration(config.Collectors.Timeout),
+		status:     e.status,
+		logger:     log.Base(),
+		metrics:    e.queryMetrics,
+	}
+	var cached *cachedCollector
+	if cache := config.Collectors.Cache; cache.MaxAge > 0 {
+		cached = newCachedCollector(wmiCollector, time.Duration(cache.MaxAge))
+		wmiCollector = cached
+	}
+
+	// A registry per configuration, so that registering the new collectors
+	// validates their descriptors without touching the one being served.
+	reg := prometheus.NewRegistry()
+	labeled := prometheus.WrapRegistererWith(config.Labels.Static, reg)
+	for _, c := range []prometheus.Collector{
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		version.NewCollector("hyperV_exporter"),
+		e.pool,
+		e.queryMetrics,
+		wmiCollector,
+	} {
+		if err := labeled.Register(c); err != nil {
+			return fmt.Errorf("couldn't register collectors: %s", err)
+		}
+	}
+
+	gatherer, err := newRelabelGatherer(reg, config.Metrics)
 	if err != nil {
-		log.Errorf("ERROR: %s collector failed after %fs: %s", "hyperV", duration.Seconds(), err)
-		success = 0
-	} else {
-		log.Debugf("OK: %s collector succeeded after %fs.", "hyperV", duration.Seconds())
-		success = 1
-	}
-	ch <- prometheus.MustNewConstMetric(
-		scrapeDurationDesc,
-		prometheus.GaugeValue,
-		duration.Seconds(),
-		"hyperV",
-	)
-	ch <- prometheus.MustNewConstMetric(
-		scrapeSuccessDesc,
-		prometheus.GaugeValue,
-		success,
-		"hyperV",
-	)
+		return fmt.Errorf("couldn't load metrics config: %s", err)
+	}
+
+	tlsConfig, err := newTLSConfig(config.Web.TLSServerConfig)
+	if err != nil {
+		return fmt.Errorf("couldn't load TLS config: %s", err)
+	}
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if e.config != nil {
+		if config.Web.ListenAddress != e.config.Web.ListenAddress || config.Web.MetricsPath != e.config.Web.MetricsPath {
+			log.Warnln("Changes to listen_address and metrics_path only take effect after a restart")
+		}
+		if (tlsConfig == nil) != (e.tls == nil) {
+			log.Warnln("Enabling or disabling TLS only takes effect after a restart, keeping the previous TLS config")
+			tlsConfig = e.tls
+		}
+		// Keep serving what the listener was started with.
+		config.Web.ListenAddress = e.config.Web.ListenAddress
+		config.Web.MetricsPath = e.config.Web.MetricsPath
+	}
+	e.config = config
+	e.handler = promhttp.InstrumentMetricHandler(labeled, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	e.tls = tlsConfig
+
+	if e.stopRefresh != nil {
+		close(e.stopRefresh)
+		e.stopRefresh = nil
+	}
+	if interval := config.Collectors.Cache.RefreshInterval; cached != nil && interval > 0 {
+		e.stopRefresh = make(chan struct{})
+		go cached.run(time.Duration(interval), e.stopRefresh)
+	}
+	log.Infof("Loaded configuration, enabled collectors: %s", strings.Join(config.Collectors.Enabled, ", "))
+	return nil
 }
 
-func loadCollector() (collector.Collector, error) {
-	return collector.NewHyperVCollector()
+// close stops the background refresh of the cache.
+func (e *exporter) close() {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if e.stopRefresh != nil {
+		close(e.stopRefresh)
+		e.stopRefresh = nil
+	}
 }
 
-func init() {
-	prometheus.MustRegister(version.NewCollector("hyperV_exporter"))
+func (e *exporter) currentConfig() *Config {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	return e.config
 }
 
-func initWbem() {
-	// This initialization prevents a memory leak on WMF 5+. See
-	// https://github.com/martinlindhe/wmi_exporter/issues/77 and linked issues
-	// for details.
-	log.Debugf("Initializing SWbemServices")
-	s, err := wmi.InitializeSWbemServices(wmi.DefaultClient)
-	if err != nil {
-		log.Fatal(err)
+// currentTLS returns the TLS settings loaded by the last successful reload.
+func (e *exporter) currentTLS() *tls.Config {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	return e.tls
+}
+
+// ServeHTTP serves the metrics of the current configuration.
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mtx.RLock()
+	h := e.handler
+	e.mtx.RUnlock()
+	h.ServeHTTP(w, r)
+}
+
+// reloadHandler reloads the configuration on POST /-/reload.
+func (e *exporter) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := e.reload(); err != nil {
+		log.Errorf("Reloading configuration failed: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// reloadOnSignal reloads the configuration whenever the process receives
+// SIGHUP.
+func (e *exporter) reloadOnSignal() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := e.reload(); err != nil {
+			log.Errorf("Reloading configuration failed: %s", err)
+		}
 	}
-	wmi.DefaultClient.SWbemServicesClient = s
 }
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  collect    run the collectors once and print the metrics")
+	fmt.Fprintln(os.Stderr, "  check      check that the WMI classes of the collectors can be queried")
+	fmt.Fprintln(os.Stderr, "  install    register the service, to run with the given flags")
+	fmt.Fprintln(os.Stderr, "  uninstall  stop and remove the service")
+	fmt.Fprintln(os.Stderr, "  start      start the service")
+	fmt.Fprintln(os.Stderr, "  stop       stop the service")
+	fmt.Fprintln(os.Stderr, "\nFlags:")
 	flag.PrintDefaults()
 }
 
 func main() {
 	var (
-		showVersion   = flag.Bool("version", false, "Print version information.")
-		listenAddress = flag.String("telemetry.addr", ":9182", "host:port for WMI exporter.")
-		metricsPath   = flag.String("telemetry.path", "/metrics", "URL path for surfacing collected metrics.")
+		showVersion       = flag.Bool("version", false, "Print version information.")
+		configFile        = flag.String("config.file", "", "Path to the YAML configuration file. Its settings take precedence over the flags.")
+		webConfigFile     = flag.String("web.config.file", "", "Path to a web configuration file enabling TLS or authentication, in the format of the Prometheus exporter toolkit.")
+		listenAddress     = flag.String("telemetry.addr", ":9182", "host:port for WMI exporter.")
+		metricsPath       = flag.String("telemetry.path", "/metrics", "URL path for surfacing collected metrics.")
+		enabledCollectors = flag.String("collectors.enabled", "", "Comma-separated list of collectors to use. Defaults to all of them: "+strings.Join(collector.Available(), ", ")+".")
+		timeout           = flag.Duration("collectors.timeout", 0, "Maximum duration of a collection, 0 to disable.")
+		cacheMaxAge       = flag.Duration("collectors.cache.max-age", 0, "Serve cached collection results younger than this duration, 0 to disable the cache.")
+		cacheRefresh      = flag.Duration("collectors.cache.refresh-interval", 0, "Refresh the cache in the background at this interval instead of during scrapes.")
+		vmInclude         = flag.String("collector.vm.include", "", "Anchored regexp of VM names to expose per-VM metrics for. Defaults to all VMs.")
+		vmExclude         = flag.String("collector.vm.exclude", "", "Anchored regexp of VM names not to expose per-VM metrics for.")
+		metricsAllow      = flag.String("metrics.allow", "", "Regexp of metric names to expose. Defaults to all metrics.")
+		metricsDeny       = flag.String("metrics.deny", "", "Regexp of metric names not to expose.")
+		failureThreshold  = flag.Duration("collectors.ready.failure-threshold", 5*time.Minute, "Duration after which failing core collectors make /ready return 503.")
+		wmiCheckInterval  = flag.Duration("wmi.health-check-interval", 30*time.Second, "Interval at which the WMI connections are checked and broken ones reconnected.")
+		shutdownTimeout   = flag.Duration("web.shutdown-timeout", 15*time.Second, "Maximum duration to wait for the requests in flight when stopping.")
+		logLevel          = flag.String("log.level", "info", "Only log messages with the given severity or above: debug, info, warn, error or fatal.")
+		logFormat         = flag.String("log.format", "logfmt", "Format of the log messages: logfmt or json.")
+		logFile           = flag.String("log.file", "", "Path to a file to also write the logs to.")
+		logFileMaxSize    = flag.Int("log.file.max-size", 10, "Size in megabytes at which the log file is rotated.")
+		logFileMaxFiles   = flag.Int("log.file.max-files", 5, "Number of rotated log files to keep.")
+		collectFormat     = flag.String("collect.format", "text", "Output format of the collect command: text, openmetrics or json.")
+		wmiRecord         = flag.String("wmi.record", "", "Directory to save the results of the local WMI queries to, as JSON files.")
+		wmiReplay         = flag.String("wmi.replay", "", "Directory of results saved with --wmi.record to serve instead of querying WMI.")
+		legacyNames       = flag.Bool("collector.hv.legacy-names", false, "Also expose hyperV_hv_gpa_pages and hyperV_hv_skipped_timer_ticks under their pre-rename names. Will be removed in the next release.")
 	)
 	flag.Usage = usage
-	flag.Parse()
+	command, args, err := parseCommand(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	flag.CommandLine.Parse(args)
 
 	if *showVersion {
 		fmt.Fprintln(os.Stdout, version.Print("hyperV_exporter"))
 		os.Exit(0)
 	}
 
-	initWbem()
+	// The service commands report to the console. They run before the
+	// logging is set up, which would open the --log.file meant for the
+	// service being installed.
+	switch command {
+	case "install", "uninstall", "start", "stop":
+		args, err := serviceArgs(flag.CommandLine)
+		if err == nil {
+			err = runCommand(command, args)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	isInteractive, err := svc.IsAnInteractiveSession()
+	asService, err := isService()
 	if err != nil {
 		log.Fatal(err)
 	}
+	logging := logConfig{
+		Level:    *logLevel,
+		Format:   *logFormat,
+		File:     *logFile,
+		MaxSize:  int64(*logFileMaxSize) << 20,
+		MaxFiles: *logFileMaxFiles,
+	}
+	if asService {
+		// stderr is discarded when running as a service.
+		logging.EventSource = serviceName
+	}
+	if err := setupLogging(logging); err != nil {
+		log.Fatal(err)
+	}
+
+	flags := Config{
+		Web: WebConfig{
+			ListenAddress: *listenAddress,
+			MetricsPath:   *metricsPath,
+		},
+		Collectors: CollectorsConfig{
+			Enabled:           splitList(*enabledCollectors),
+			Timeout:           model.Duration(*timeout),
+			LegacyMetricNames: *legacyNames,
+			VMInclude:         *vmInclude,
+			VMExclude:         *vmExclude,
+			Ready: ReadyConfig{
+				FailureThreshold: model.Duration(*failureThreshold),
+			},
+			Cache: CacheConfig{
+				MaxAge:          model.Duration(*cacheMaxAge),
+				RefreshInterval: model.Duration(*cacheRefresh),
+			},
+		},
+		Metrics: MetricsConfig{
+			Allow: *metricsAllow,
+			Deny:  *metricsDeny,
+		},
+	}
 
-	stopCh := make(chan bool)
-	if !isInteractive {
-		go svc.Run(serviceName, &wmiExporterService{stopCh: stopCh})
+	if command == "collect" {
+		config, err := loadConfig(*configFile, "", flags)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't load config file %s: %s\n", *configFile, err)
+			os.Exit(2)
+		}
+		querier, err := localQuerier(collector.WMIQuerier{}, *wmiRecord, *wmiReplay)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		failed, err := collectOnce(os.Stdout, config, querier, *collectFormat)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		for _, name := range sortedNames(failed) {
+			fmt.Fprintf(os.Stderr, "collector %s failed: %s\n", name, failed[name])
+		}
+		if len(failed) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "check" {
+		config, err := loadConfig(*configFile, "", flags)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't load config file %s: %s\n", *configFile, err)
+			os.Exit(2)
+		}
+		querier, err := localQuerier(collector.WMIQuerier{}, *wmiRecord, *wmiReplay)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		collectors, err := loadCollectors(config, config.Collectors.Enabled, querier, nil, log.Base())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't load collectors: %s\n", err)
+			os.Exit(2)
+		}
+		if !checkCollectors(os.Stdout, collectors) {
+			os.Exit(1)
+		}
+		return
 	}
 
-	collector, err := loadCollector()
+	namespaces := []string{`root\cimv2`, `root\virtualization\v2`}
+	if *wmiReplay != "" {
+		// The local collectors do not query WMI.
+		namespaces = nil
+	}
+	pool := collector.NewPool(namespaces)
+	querier, err := localQuerier(pool, *wmiRecord, *wmiReplay)
 	if err != nil {
-		log.Fatalf("Couldn't load collector: %s", err)
+		log.Fatal(err)
 	}
+	stopPool := make(chan struct{})
+	go pool.Run(*wmiCheckInterval, stopPool)
 
-	hyperVCollector := WmiCollector{collector: collector}
-	prometheus.MustRegister(hyperVCollector)
+	e := &exporter{
+		configFile:    *configFile,
+		webConfigFile: *webConfigFile,
+		pool:          pool,
+		querier:       querier,
+		queryMetrics:  collector.NewQueryMetrics(),
+		status:        newStatusTracker(),
+		flags:         flags,
+	}
+	if err := e.reload(); err != nil {
+		log.Fatal(err)
+	}
+	config := e.currentConfig()
 
-	http.Handle(*metricsPath, promhttp.Handler())
+	http.Handle(config.Web.MetricsPath, e)
 	http.HandleFunc("/health", healthCheck)
+	http.HandleFunc("/ready", e.status.readyHandler(e.currentConfig))
+	http.HandleFunc("/-/reload", e.reloadHandler)
+	http.Handle("/probe", probeHandler{config: e.currentConfig, newQuerier: newQuerier})
 
 	// landingPage contains the HTML served at '/'.
 	// TODO: Make this nicer and more informative.
@@ -150,7 +391,7 @@ func main() {
 	<head><title>Hyper-V exporter</title></head>
 	<body>
 	<h1>Hyper-V exporter</h1>
-	<p><a href='` + *metricsPath + `'>Metrics</a></p>
+	<p><a href='` + config.Web.MetricsPath + `'>Metrics</a></p>
 	</body>
 	</html>
 	`)
@@ -161,48 +402,53 @@ func main() {
 	log.Infoln("Starting HyperV exporter", version.Info())
 	log.Infoln("Build context", version.BuildContext())
 
-	go func() {
-		log.Infoln("Starting server on", *listenAddress)
-		log.Fatalf("cannot start HyperV exporter: %s", http.ListenAndServe(*listenAddress, nil))
-	}()
+	go e.reloadOnSignal()
 
-	for {
-		if <-stopCh {
-			log.Info("Shutting down HyperV exporter")
-			break
+	server := &http.Server{
+		Addr:    config.Web.ListenAddress,
+		Handler: authenticate(e.currentConfig, http.DefaultServeMux),
+	}
+	if config.Web.TLSServerConfig.CertFile != "" {
+		server.TLSConfig = reloadableTLSConfig(e.currentTLS)
+	}
+	run := func(ctx context.Context, started func()) error {
+		l, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			return fmt.Errorf("cannot start HyperV exporter: %s", err)
 		}
+		log.Infoln("Starting server on", config.Web.ListenAddress)
+		started()
+		return serve(ctx, server, l, *shutdownTimeout)
 	}
 
+	if asService {
+		err = runAsService(run, *shutdownTimeout)
+	} else {
+		err = runService(newConsoleHost(), run)
+	}
+	e.close()
+	close(stopPool)
+	pool.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Info("HyperV exporter stopped")
+}
+
+// splitList splits a comma-separated flag value, ignoring empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
+// healthCheck reports that the exporter is alive. Whether the collectors work
+// is reported by /ready.
 func healthCheck(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	io.WriteString(w, `{"status":"ok"}`)
 }
-
-type wmiExporterService struct {
-	stopCh chan<- bool
-}
-
-func (s *wmiExporterService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
-	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown
-	changes <- svc.Status{State: svc.StartPending}
-	changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
-loop:
-	for {
-		select {
-		case c := <-r:
-			switch c.Cmd {
-			case svc.Interrogate:
-				changes <- c.CurrentStatus
-			case svc.Stop, svc.Shutdown:
-				s.stopCh <- true
-				break loop
-			default:
-				log.Error(fmt.Sprintf("unexpected control request #%d", c))
-			}
-		}
-	}
-	changes <- svc.Status{State: svc.StopPending}
-	return
-}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// commands are the subcommands: collect, check, and those managing the
+// Windows service.
+var commands = []string{"collect", "check", "install", "uninstall", "start", "stop"}
+
+// parseCommand splits the subcommand, if any, from the flags in args.
+func parseCommand(args []string) (string, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "", args, nil
+	}
+	for _, c := range commands {
+		if args[0] == c {
+			return c, args[1:], nil
+		}
+	}
+	return "", nil, fmt.Errorf("unknown command %q, expected one of %s", args[0], strings.Join(commands, ", "))
+}
+
+// fileFlags hold paths, which are relative to the working directory.
+var fileFlags = map[string]bool{
+	"config.file":     true,
+	"web.config.file": true,
+	"log.file":        true,
+	"wmi.record":      true,
+	"wmi.replay":      true,
+}
+
+// serviceArgs returns the flags set on fs, for the service to run with them.
+// Paths are made absolute, as services run from the system directory.
+func serviceArgs(fs *flag.FlagSet) ([]string, error) {
+	var (
+		args []string
+		err  error
+	)
+	fs.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		if fileFlags[f.Name] && value != "" {
+			abs, absErr := filepath.Abs(value)
+			if absErr != nil {
+				err = absErr
+				return
+			}
+			value = abs
+		}
+		args = append(args, fmt.Sprintf("--%s=%s", f.Name, value))
+	})
+	return args, err
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+)
+
+// runCommand fails, as services are only supported on Windows.
+func runCommand(command string, args []string) error {
+	return fmt.Errorf("the %s command is only supported on Windows", command)
+}
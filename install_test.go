@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCommand(t *testing.T) {
+	for _, tc := range []struct {
+		args        []string
+		wantCommand string
+		wantArgs    []string
+	}{
+		{nil, "", nil},
+		{[]string{"--telemetry.addr=:9999"}, "", []string{"--telemetry.addr=:9999"}},
+		{[]string{"install", "--telemetry.addr=:9999"}, "install", []string{"--telemetry.addr=:9999"}},
+		{[]string{"stop"}, "stop", []string{}},
+	} {
+		command, args, err := parseCommand(tc.args)
+		if err != nil {
+			t.Errorf("%v: %s", tc.args, err)
+			continue
+		}
+		if command != tc.wantCommand || !reflect.DeepEqual(args, tc.wantArgs) {
+			t.Errorf("%v: got %q %v, want %q %v", tc.args, command, args, tc.wantCommand, tc.wantArgs)
+		}
+	}
+
+	if _, _, err := parseCommand([]string{"restart"}); err == nil {
+		t.Error("expected an error for an unknown command")
+	}
+}
+
+func TestServiceArgs(t *testing.T) {
+	fs := flag.NewFlagSet("hyperV_exporter", flag.ContinueOnError)
+	fs.String("config.file", "", "")
+	fs.String("telemetry.addr", ":9182", "")
+	fs.Duration("collectors.timeout", 0, "")
+	fs.Bool("collector.hv.legacy-names", false, "")
+	fs.String("log.file", "", "")
+	if err := fs.Parse([]string{"--config.file", "hyperV.yml", "--collectors.timeout=10s", "--collector.hv.legacy-names", "--log.file=logs/exporter.log"}); err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := serviceArgs(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"--collector.hv.legacy-names=true",
+		"--collectors.timeout=" + (10 * time.Second).String(),
+		"--config.file=" + filepath.Join(wd, "hyperV.yml"),
+		"--log.file=" + filepath.Join(wd, "logs", "exporter.log"),
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("got %v, want %v", args, want)
+	}
+}
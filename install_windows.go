@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// recoveryActions restart the service when it fails, backing off up to a
+// minute. The count of failures is reset after a day without any.
+var (
+	recoveryActions = []mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: time.Minute},
+	}
+	recoveryResetPeriod = 24 * time.Hour
+)
+
+// runCommand runs a subcommand managing the service. args are the flags to
+// install the service with.
+func runCommand(command string, args []string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("couldn't connect to the service manager: %s", err)
+	}
+	defer m.Disconnect()
+
+	if command == "install" {
+		return installService(m, args)
+	}
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("couldn't open service %s: %s", serviceName, err)
+	}
+	defer s.Close()
+
+	switch command {
+	case "uninstall":
+		status, err := s.Query()
+		if err != nil {
+			return err
+		}
+		if status.State != svc.Stopped {
+			if err := stopService(s); err != nil {
+				return err
+			}
+		}
+		if err := s.Delete(); err != nil {
+			return fmt.Errorf("couldn't delete service %s: %s", serviceName, err)
+		}
+		if err := removeEventSource(); err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't remove the Event Log source %s: %s\n", serviceName, err)
+		}
+		fmt.Printf("Service %s uninstalled\n", serviceName)
+	case "start":
+		if err := s.Start(); err != nil {
+			return fmt.Errorf("couldn't start service %s: %s", serviceName, err)
+		}
+		if err := waitState(s, svc.Running, time.Minute); err != nil {
+			return err
+		}
+		fmt.Printf("Service %s started\n", serviceName)
+	case "stop":
+		if err := stopService(s); err != nil {
+			return err
+		}
+		fmt.Printf("Service %s stopped\n", serviceName)
+	}
+	return nil
+}
+
+func installService(m *mgr.Mgr, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists, uninstall it first to change its flags", serviceName)
+	}
+
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName:      "Hyper-V exporter",
+		Description:      "Prometheus exporter for Hyper-V metrics",
+		StartType:        mgr.StartAutomatic,
+		DelayedAutoStart: true,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("couldn't create service %s: %s", serviceName, err)
+	}
+	defer s.Close()
+
+	if err := s.SetRecoveryActions(recoveryActions, uint32(recoveryResetPeriod/time.Second)); err != nil {
+		s.Delete()
+		return fmt.Errorf("couldn't set recovery actions of service %s: %s", serviceName, err)
+	}
+	if err := installEventSource(); err != nil {
+		// The source is kept when reinstalling.
+		fmt.Fprintf(os.Stderr, "Couldn't register the Event Log source %s: %s\n", serviceName, err)
+	}
+	fmt.Printf("Service %s installed, running %s %v\n", serviceName, exe, args)
+	return nil
+}
+
+func stopService(s *mgr.Service) error {
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return fmt.Errorf("couldn't stop service %s: %s", serviceName, err)
+	}
+	// Give the exporter the time it asked for to drain the scrapes.
+	timeout := 30*time.Second + time.Duration(status.WaitHint)*time.Millisecond
+	return waitState(s, svc.Stopped, timeout)
+}
+
+// waitState waits for the service to reach state.
+func waitState(s *mgr.Service, state svc.State, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := s.Query()
+		if err != nil {
+			return err
+		}
+		if status.State == state {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service %s did not reach state %d after %s", serviceName, state, timeout)
+		}
+		time.Sleep(300 * time.Millisecond)
+	}
+}
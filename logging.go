@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/prometheus/common/log"
+	"github.com/sirupsen/logrus"
+)
+
+// logConfig configures the logger shared by main and the collectors.
+type logConfig struct {
+	// Level is one of debug, info, warn, error or fatal.
+	Level string
+	// Format is logfmt or json.
+	Format string
+	// File, if set, also receives the logs, and is rotated when it reaches
+	// MaxSize bytes. MaxFiles rotated files are kept.
+	File     string
+	MaxSize  int64
+	MaxFiles int
+	// EventSource, if set, also sends the logs to the Windows Event Log
+	// under this source name.
+	EventSource string
+}
+
+// setupLogging applies c to the logger of github.com/prometheus/common/log.
+// Logs are always written to stderr; the file and the Event Log are added as
+// hooks, so that they receive the entries formatted the same way.
+func setupLogging(c logConfig) error {
+	if err := log.Base().SetLevel(c.Level); err != nil {
+		return fmt.Errorf("invalid log level %q: %s", c.Level, err)
+	}
+	switch c.Format {
+	case "", "logfmt":
+	case "json":
+		if err := log.Base().SetFormat("logger:stderr?json=true"); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid log format %q, expected logfmt or json", c.Format)
+	}
+
+	if c.File != "" {
+		f, err := openRotatingFile(c.File, c.MaxSize, c.MaxFiles)
+		if err != nil {
+			return fmt.Errorf("couldn't open log file: %s", err)
+		}
+		log.AddHook(writerHook{w: f})
+	}
+	if c.EventSource != "" {
+		h, err := newEventLogHook(c.EventSource)
+		if err != nil {
+			return fmt.Errorf("couldn't open the Event Log: %s", err)
+		}
+		log.AddHook(h)
+	}
+	return nil
+}
+
+// writerHook writes every entry to w.
+type writerHook struct {
+	w io.Writer
+}
+
+func (h writerHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h writerHook) Fire(e *logrus.Entry) error {
+	b, err := e.Bytes()
+	if err != nil {
+		return err
+	}
+	_, err = h.w.Write(b)
+	return err
+}
+
+// rotatingFile is a log file that is renamed to path.1 when a write would
+// make it larger than maxSize, path.1 being renamed to path.2 and so on up to
+// maxFiles.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mtx  sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxFiles))
+	for i := r.maxFiles - 1; i > 0; i-- {
+		// Files missing after a change of maxFiles are fine.
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if r.maxFiles > 0 {
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.f.Close()
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newEventLogHook(source string) (logrus.Hook, error) {
+	return nil, errors.New("the Event Log is only available on Windows")
+}
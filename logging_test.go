@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hyperV_exporter.log")
+
+	// The existing content counts towards the size.
+	if err := ioutil.WriteFile(path, []byte("0000000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := openRotatingFile(path, 16, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, line := range []string{"1111111\n", "2222222\n", "3333333\n", "4444444\n", "5555555\n", "6666666\n", "7777777\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string]string{
+		"":   "6666666\n7777777\n",
+		".1": "4444444\n5555555\n",
+		".2": "2222222\n3333333\n",
+	} {
+		b, err := ioutil.ReadFile(path + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("%s: got %q, want %q", path+name, b, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 rotated files, got %v", err)
+	}
+}
+
+func TestSetupLoggingErrors(t *testing.T) {
+	for _, c := range []logConfig{
+		{Level: "verbose"},
+		{Level: "info", Format: "xml"},
+		{Level: "info", File: filepath.Join("does", "not", "exist.log")},
+	} {
+		if err := setupLogging(c); err == nil {
+			t.Errorf("%+v: expected an error", c)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// Event IDs of the entries, by severity.
+const (
+	eventInfo    = 1
+	eventWarning = 2
+	eventError   = 3
+)
+
+// eventLogHook sends the entries to the Windows Event Log.
+type eventLogHook struct {
+	log *eventlog.Log
+}
+
+func newEventLogHook(source string) (logrus.Hook, error) {
+	l, err := eventlog.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	return eventLogHook{log: l}, nil
+}
+
+func (h eventLogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h eventLogHook) Fire(e *logrus.Entry) error {
+	b, err := e.Bytes()
+	if err != nil {
+		return err
+	}
+	msg := string(b)
+	switch e.Level {
+	case logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel:
+		return h.log.Error(eventError, msg)
+	case logrus.WarnLevel:
+		return h.log.Warning(eventWarning, msg)
+	default:
+		return h.log.Info(eventInfo, msg)
+	}
+}
+
+// installEventSource registers the service as a source of the Event Log, so
+// that Event Viewer displays its messages.
+func installEventSource() error {
+	return eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info)
+}
+
+func removeEventSource() error {
+	return eventlog.Remove(serviceName)
+}
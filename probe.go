@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/iyacontrol/HyperV-exporter/collector"
+	"github.com/iyacontrol/HyperV-exporter/wsman"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/log"
+)
+
+// probeHandler serves /probe?target=host&module=name, running the collectors
+// of the module against a remote host, in the style of the blackbox and snmp
+// exporters.
+type probeHandler struct {
+	config func() *Config
+	// newQuerier connects to the target with the credentials of the module.
+	newQuerier func(target string, module ModuleConfig) collector.Querier
+}
+
+// newQuerier queries the target with the protocol of the module.
+func newQuerier(target string, module ModuleConfig) collector.Querier {
+	if module.Protocol == "winrm" {
+		return wsman.NewClient(target, module.WinRM.HTTPS, module.WinRM.InsecureSkipVerify, module.Username, module.Password)
+	}
+	return collector.WMIQuerier{
+		Host:     target,
+		Username: module.Username,
+		Password: module.Password,
+	}
+}
+
+func (h probeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	config := h.config()
+	params := r.URL.Query()
+
+	target := params.Get("target")
+	if target == "" {
+		http.Error(w, "'target' parameter must be specified", http.StatusBadRequest)
+		return
+	}
+	moduleName := params.Get("module")
+	if moduleName == "" {
+		moduleName = "default"
+	}
+	module, ok := config.Modules[moduleName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown module %q", moduleName), http.StatusBadRequest)
+		return
+	}
+	if !module.allowsTarget(target) {
+		http.Error(w, fmt.Sprintf("Target %q is not allowed for module %q", target, moduleName), http.StatusBadRequest)
+		return
+	}
+
+	logger := log.With("target", target).With("module", moduleName)
+	collectors, err := loadCollectors(config, module.Collectors, h.newQuerier(target, module), nil, logger)
+	if err != nil {
+		logger.Errorf("Couldn't load collectors: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	reg := prometheus.NewRegistry()
+	labeled := prometheus.WrapRegistererWith(config.Labels.Static, reg)
+	if err := labeled.Register(WmiCollector{collectors: collectors, timeout: time.Duration(config.Collectors.Timeout), logger: logger}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	gatherer, err := newRelabelGatherer(reg, config.Metrics)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iyacontrol/HyperV-exporter/collector"
+	"github.com/iyacontrol/HyperV-exporter/wsman"
+)
+
+// stubQuerier answers the queries of the processor collector and fails the
+// others.
+type stubQuerier struct {
+	target string
+	module ModuleConfig
+}
+
+func (q *stubQuerier) Query(query string, dst interface{}, namespace string) error {
+	rows, ok := dst.(*[]collector.Win32_PerfRawData_HvStats_HyperVHypervisor)
+	if !ok {
+		return errors.New("unexpected query " + query)
+	}
+	*rows = append(*rows, collector.Win32_PerfRawData_HvStats_HyperVHypervisor{
+		LogicalProcessors: 8,
+		VirtualProcessors: 12,
+	})
+	return nil
+}
+
+func newProbeServer(t *testing.T, q *stubQuerier) *httptest.Server {
+	config, err := loadConfig("", "", Config{Modules: map[string]ModuleConfig{
+		"remote": {
+			Collectors: []string{"processor", "vid"},
+			Username:   `CORP\monitoring`,
+			Password:   "secret",
+			Targets:    []string{"hv01.example.com", `hv\d+\.lab\.example\.com`},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(probeHandler{
+		config: func() *Config { return config },
+		newQuerier: func(target string, module ModuleConfig) collector.Querier {
+			q.target, q.module = target, module
+			return q
+		},
+	})
+}
+
+func TestProbe(t *testing.T) {
+	q := &stubQuerier{}
+	server := newProbeServer(t, q)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?target=hv01.example.com&module=remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", resp.StatusCode, body)
+	}
+
+	if q.target != "hv01.example.com" || q.module.Username != `CORP\monitoring` || q.module.Password != "secret" {
+		t.Errorf("querier created for %q with %+v", q.target, q.module)
+	}
+	for _, want := range []string{
+		"hyperV_processor_logical_processors 8",
+		"hyperV_processor_virtual_processors 12",
+		`hyperV_exporter_collector_success{collector="processor"} 1`,
+		`hyperV_exporter_collector_success{collector="vid"} 0`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("%q is missing from the response:\n%s", want, body)
+		}
+	}
+	if strings.Contains(string(body), "hyperV_health_") {
+		t.Error("collectors outside of the module were run")
+	}
+}
+
+func TestProbeBadRequest(t *testing.T) {
+	server := newProbeServer(t, &stubQuerier{})
+	defer server.Close()
+
+	for _, query := range []string{
+		"",
+		"?module=remote",
+		"?target=hv01&module=missing",
+		"?target=mail.example.com&module=remote",
+		"?target=hv01.example.com.attacker.net&module=remote",
+	} {
+		resp, err := http.Get(server.URL + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%q: got status %d, want %d", query, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestModuleValidation(t *testing.T) {
+	for name, module := range map[string]ModuleConfig{
+		"unknown protocol":           {Protocol: "ssh"},
+		"invalid targets":            {Targets: []string{"hv("}},
+		"winrm credentials in clear": {Protocol: "winrm", Username: "monitoring", Password: "secret"},
+		"winrm credentials unverified": {Protocol: "winrm", Username: "monitoring", Password: "secret",
+			WinRM: WinRMConfig{HTTPS: true, InsecureSkipVerify: true}},
+	} {
+		if _, err := loadConfig("", "", Config{Modules: map[string]ModuleConfig{"m": module}}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	for name, module := range map[string]ModuleConfig{
+		"winrm over https":                     {Protocol: "winrm", Username: "monitoring", Password: "secret", WinRM: WinRMConfig{HTTPS: true}},
+		"winrm without credentials":            {Protocol: "winrm"},
+		"winrm unverified without credentials": {Protocol: "winrm", WinRM: WinRMConfig{HTTPS: true, InsecureSkipVerify: true}},
+		"wmi credentials":                      {Username: "monitoring", Password: "secret"},
+	} {
+		if _, err := loadConfig("", "", Config{Modules: map[string]ModuleConfig{"m": module}}); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+}
+
+func TestModuleTargets(t *testing.T) {
+	config, err := loadConfig("", "", Config{Modules: map[string]ModuleConfig{
+		"m": {Targets: []string{"hv01.example.com", `hv\d+\.lab\.example\.com`}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for target, want := range map[string]bool{
+		"hv01.example.com":      true,
+		"hv12.lab.example.com":  true,
+		"hv01.example.com:5986": false,
+		"hv.lab.example.com":    false,
+		"web01.lab.example.com": false,
+		"hv01.example.com.evil": false,
+		"evil/hv01.example.com": false,
+	} {
+		if got := config.Modules["m"].allowsTarget(target); got != want {
+			t.Errorf("%s: got %v, want %v", target, got, want)
+		}
+	}
+	// Modules without targets accept any target.
+	if !config.Modules["default"].allowsTarget("hv99.example.com") {
+		t.Error("default module rejected a target")
+	}
+}
+
+func TestNewQuerier(t *testing.T) {
+	q := newQuerier("hv01", ModuleConfig{Protocol: "winrm", Username: "monitoring", WinRM: WinRMConfig{HTTPS: true}})
+	if c, ok := q.(*wsman.Client); !ok || c.Endpoint != "https://hv01:5986/wsman" || c.Username != "monitoring" {
+		t.Errorf("got %#v for the winrm protocol", q)
+	}
+	if q := newQuerier("hv01", ModuleConfig{Protocol: "wmi"}); q != (collector.WMIQuerier{Host: "hv01"}) {
+		t.Errorf("got %#v for the wmi protocol", q)
+	}
+}
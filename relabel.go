@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// relabelGatherer filters and relabels the metric families of the wrapped
+// Gatherer before they are exposed, for setups where the Prometheus server
+// scraping the exporter cannot apply metric_relabel_configs itself.
+type relabelGatherer struct {
+	gatherer    prometheus.Gatherer
+	allow, deny *regexp.Regexp
+	rules       []labelRule
+}
+
+type labelRule struct {
+	metrics *regexp.Regexp
+	action  string
+	label   string
+	target  string
+}
+
+// newRelabelGatherer validates config and wraps g with it.
+func newRelabelGatherer(g prometheus.Gatherer, config MetricsConfig) (*relabelGatherer, error) {
+	r := &relabelGatherer{gatherer: g}
+	var err error
+	if r.allow, err = compileAnchored(config.Allow); err != nil {
+		return nil, fmt.Errorf("invalid allow: %s", err)
+	}
+	if r.deny, err = compileAnchored(config.Deny); err != nil {
+		return nil, fmt.Errorf("invalid deny: %s", err)
+	}
+
+	for i, rule := range config.LabelRules {
+		lr := labelRule{action: rule.Action, label: rule.Label, target: rule.TargetLabel}
+		if lr.metrics, err = compileAnchored(rule.Metrics); err != nil {
+			return nil, fmt.Errorf("label rule %d: invalid metrics: %s", i, err)
+		}
+		if !model.LabelName(lr.label).IsValid() {
+			return nil, fmt.Errorf("label rule %d: invalid label %q", i, lr.label)
+		}
+		switch lr.action {
+		case "rename":
+			if !model.LabelName(lr.target).IsValid() || strings.HasPrefix(lr.target, model.ReservedLabelPrefix) {
+				return nil, fmt.Errorf("label rule %d: invalid target_label %q", i, lr.target)
+			}
+		case "drop":
+			if lr.target != "" {
+				return nil, fmt.Errorf("label rule %d: target_label is only used by rename", i)
+			}
+		default:
+			return nil, fmt.Errorf("label rule %d: unknown action %q, must be rename or drop", i, lr.action)
+		}
+		r.rules = append(r.rules, lr)
+	}
+	return r, nil
+}
+
+// apply renames or drops the label of the rule on m. The label pairs of m are
+// shared with the metrics they were written from, which are written again on
+// the next scrape, or by the cache on every scrape, so m gets a new slice and
+// renamed labels new pairs.
+func (rule labelRule) apply(m *dto.Metric) error {
+	labels := make([]*dto.LabelPair, 0, len(m.Label))
+	for _, lp := range m.Label {
+		switch name := lp.GetName(); {
+		case rule.action == "rename" && name == rule.target:
+			return fmt.Errorf("cannot rename %s, label %s already exists", rule.label, rule.target)
+		case name != rule.label:
+			labels = append(labels, lp)
+		case rule.action == "rename":
+			target := rule.target
+			labels = append(labels, &dto.LabelPair{Name: &target, Value: lp.Value})
+		}
+	}
+	m.Label = labels
+	return nil
+}
+
+// compileAnchored compiles a regular expression matching whole strings, like
+// the regexes of Prometheus relabeling. It returns nil for an empty
+// expression.
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+// Gather implements prometheus.Gatherer. Families whose relabeling fails are
+// dropped and the error is returned alongside the remaining families.
+func (r *relabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := r.gatherer.Gather()
+	errs := prometheus.MultiError{}
+	errs.Append(err)
+
+	kept := mfs[:0]
+	for _, mf := range mfs {
+		name := mf.GetName()
+		if r.allow != nil && !r.allow.MatchString(name) {
+			continue
+		}
+		if r.deny != nil && r.deny.MatchString(name) {
+			continue
+		}
+		if err := r.relabel(mf); err != nil {
+			errs.Append(err)
+			continue
+		}
+		kept = append(kept, mf)
+	}
+	return kept, errs.MaybeUnwrap()
+}
+
+// relabel applies the label rules matching the family to each of its
+// metrics, in order.
+func (r *relabelGatherer) relabel(mf *dto.MetricFamily) error {
+	changed := false
+	for _, rule := range r.rules {
+		if rule.metrics != nil && !rule.metrics.MatchString(mf.GetName()) {
+			continue
+		}
+		changed = true
+		for _, m := range mf.Metric {
+			if err := rule.apply(m); err != nil {
+				return fmt.Errorf("relabeling %s: %s", mf.GetName(), err)
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	// Dropping a label can make series of the family indistinguishable. The
+	// rules gave every metric its own Label slice, so it can be sorted.
+	seen := map[string]bool{}
+	for _, m := range mf.Metric {
+		sort.Slice(m.Label, func(i, j int) bool {
+			return m.Label[i].GetName() < m.Label[j].GetName()
+		})
+		var sig strings.Builder
+		for _, lp := range m.Label {
+			fmt.Fprintf(&sig, "%s=%q,", lp.GetName(), lp.GetValue())
+		}
+		if seen[sig.String()] {
+			return fmt.Errorf("relabeling %s produced duplicate series {%s}", mf.GetName(), strings.TrimSuffix(sig.String(), ","))
+		}
+		seen[sig.String()] = true
+	}
+	return nil
+}
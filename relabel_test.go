@@ -0,0 +1,237 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iyacontrol/HyperV-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	pages := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "hyperV_vid_remote_physical_pages",
+		Help: "Remote pages.",
+	}, []string{"vm", "node"})
+	pages.WithLabelValues("web01", "0").Set(1)
+	pages.WithLabelValues("web01", "1").Set(2)
+	reg.MustRegister(pages)
+
+	ok := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "hyperV_health_ok",
+		Help: "Healthy VMs.",
+	})
+	ok.Set(3)
+	reg.MustRegister(ok)
+	return reg
+}
+
+func TestRelabelGatherer(t *testing.T) {
+	for name, test := range map[string]struct {
+		config MetricsConfig
+		want   string
+	}{
+		"no rules": {
+			want: `
+# HELP hyperV_health_ok Healthy VMs.
+# TYPE hyperV_health_ok gauge
+hyperV_health_ok 3
+# HELP hyperV_vid_remote_physical_pages Remote pages.
+# TYPE hyperV_vid_remote_physical_pages gauge
+hyperV_vid_remote_physical_pages{node="0",vm="web01"} 1
+hyperV_vid_remote_physical_pages{node="1",vm="web01"} 2
+`,
+		},
+		"allow": {
+			config: MetricsConfig{Allow: "hyperV_health_.*"},
+			want: `
+# HELP hyperV_health_ok Healthy VMs.
+# TYPE hyperV_health_ok gauge
+hyperV_health_ok 3
+`,
+		},
+		"deny is anchored": {
+			config: MetricsConfig{Deny: "hyperV_health"},
+			want: `
+# HELP hyperV_health_ok Healthy VMs.
+# TYPE hyperV_health_ok gauge
+hyperV_health_ok 3
+# HELP hyperV_vid_remote_physical_pages Remote pages.
+# TYPE hyperV_vid_remote_physical_pages gauge
+hyperV_vid_remote_physical_pages{node="0",vm="web01"} 1
+hyperV_vid_remote_physical_pages{node="1",vm="web01"} 2
+`,
+		},
+		"rename": {
+			config: MetricsConfig{LabelRules: []LabelRule{
+				{Action: "rename", Label: "vm", TargetLabel: "instance_name"},
+			}},
+			want: `
+# HELP hyperV_health_ok Healthy VMs.
+# TYPE hyperV_health_ok gauge
+hyperV_health_ok 3
+# HELP hyperV_vid_remote_physical_pages Remote pages.
+# TYPE hyperV_vid_remote_physical_pages gauge
+hyperV_vid_remote_physical_pages{instance_name="web01",node="0"} 1
+hyperV_vid_remote_physical_pages{instance_name="web01",node="1"} 2
+`,
+		},
+		"drop": {
+			config: MetricsConfig{LabelRules: []LabelRule{
+				{Action: "drop", Label: "vm", Metrics: "hyperV_vid_.*"},
+			}},
+			want: `
+# HELP hyperV_health_ok Healthy VMs.
+# TYPE hyperV_health_ok gauge
+hyperV_health_ok 3
+# HELP hyperV_vid_remote_physical_pages Remote pages.
+# TYPE hyperV_vid_remote_physical_pages gauge
+hyperV_vid_remote_physical_pages{node="0"} 1
+hyperV_vid_remote_physical_pages{node="1"} 2
+`,
+		},
+	} {
+		g, err := newRelabelGatherer(newTestRegistry(), test.config)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if err := testutil.GatherAndCompare(g, strings.NewReader(test.want)); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+}
+
+// TestRelabelGathererIsRepeatable checks that relabeling leaves the label
+// pairs of the collected metrics untouched, for the next scrapes.
+func TestRelabelGathererIsRepeatable(t *testing.T) {
+	config := MetricsConfig{LabelRules: []LabelRule{
+		{Action: "rename", Label: "vm", TargetLabel: "instance_name"},
+	}}
+	want := `
+# HELP hyperV_vid_remote_physical_pages Remote pages.
+# TYPE hyperV_vid_remote_physical_pages gauge
+hyperV_vid_remote_physical_pages{instance_name="web01",node="0"} 1
+hyperV_vid_remote_physical_pages{instance_name="web01",node="1"} 2
+`
+	pages := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "hyperV_vid_remote_physical_pages",
+		Help: "Remote pages.",
+	}, []string{"vm", "node"})
+	pages.WithLabelValues("web01", "0").Set(1)
+	pages.WithLabelValues("web01", "1").Set(2)
+
+	for name, c := range map[string]prometheus.Collector{
+		"registry": pages,
+		// The cache sends the same metrics on every scrape.
+		"cache": newCachedCollector(pages, time.Hour),
+	} {
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(c)
+		g, err := newRelabelGatherer(reg, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := testutil.GatherAndCompare(g, strings.NewReader(want), "hyperV_vid_remote_physical_pages"); err != nil {
+				t.Errorf("%s: gather %d: %s", name, i+1, err)
+			}
+		}
+	}
+}
+
+func TestRelabelGathererErrors(t *testing.T) {
+	for name, rules := range map[string][]LabelRule{
+		"duplicate series": {{Action: "drop", Label: "node"}},
+		"existing target":  {{Action: "rename", Label: "vm", TargetLabel: "node"}},
+	} {
+		g, err := newRelabelGatherer(newTestRegistry(), MetricsConfig{LabelRules: rules})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		mfs, err := g.Gather()
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+		if len(mfs) != 1 || mfs[0].GetName() != "hyperV_health_ok" {
+			t.Errorf("%s: only the family without errors should be kept, got %v", name, mfs)
+		}
+	}
+}
+
+func TestRelabelConfigValidation(t *testing.T) {
+	for name, config := range map[string]MetricsConfig{
+		"invalid allow":    {Allow: "("},
+		"unknown action":   {LabelRules: []LabelRule{{Action: "keep", Label: "vm"}}},
+		"missing target":   {LabelRules: []LabelRule{{Action: "rename", Label: "vm"}}},
+		"reserved target":  {LabelRules: []LabelRule{{Action: "rename", Label: "vm", TargetLabel: "__name__"}}},
+		"target with drop": {LabelRules: []LabelRule{{Action: "drop", Label: "vm", TargetLabel: "x"}}},
+	} {
+		if _, err := newRelabelGatherer(prometheus.NewRegistry(), config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// TestReloadRelabelsHandlerMetrics checks that the metrics of the metrics
+// handler get the static labels and go through the relabeling, like the
+// metrics of the collectors.
+func TestReloadRelabelsHandlerMetrics(t *testing.T) {
+	e := &exporter{
+		pool:         collector.NewPool(nil),
+		querier:      &stubQuerier{},
+		queryMetrics: collector.NewQueryMetrics(),
+		status:       newStatusTracker(),
+		flags: Config{
+			Collectors: CollectorsConfig{Enabled: []string{"hv"}},
+			Labels:     LabelsConfig{Static: prometheus.Labels{"site": "dc1"}},
+			Metrics: MetricsConfig{LabelRules: []LabelRule{
+				{Action: "rename", Label: "site", TargetLabel: "datacenter"},
+			}},
+		},
+	}
+	if err := e.reload(); err != nil {
+		t.Fatal(err)
+	}
+	var body string
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		body = rec.Body.String()
+	}
+	for _, want := range []string{
+		`promhttp_metric_handler_requests_total{code="200",datacenter="dc1"} 1`,
+		`promhttp_metric_handler_requests_in_flight{datacenter="dc1"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics do not contain %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestCompileAnchored(t *testing.T) {
+	for name, test := range map[string]struct {
+		expr, s string
+		want    bool
+	}{
+		"whole name":       {"web01", "web01", true},
+		"prefix":           {"web", "web01", false},
+		"wildcard":         {"web.*", "web01", true},
+		"alternation":      {"web01|vdi", "vdi", true},
+		"alternation part": {"web01|vdi", "vdi-01", false},
+	} {
+		re, err := compileAnchored(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := re.MatchString(test.s); got != test.want {
+			t.Errorf("%s: %q matching %q = %v, want %v", name, test.expr, test.s, got, test.want)
+		}
+	}
+	if re, err := compileAnchored(""); re != nil || err != nil {
+		t.Errorf("got %v, %v for an empty expression, want no filter", re, err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/common/log"
+)
+
+// serviceState is the state of the exporter as reported to the service
+// control manager.
+type serviceState int
+
+const (
+	stateStartPending serviceState = iota
+	stateRunning
+	stateStopPending
+	stateStopped
+)
+
+func (s serviceState) String() string {
+	switch s {
+	case stateStartPending:
+		return "StartPending"
+	case stateRunning:
+		return "Running"
+	case stateStopPending:
+		return "StopPending"
+	case stateStopped:
+		return "Stopped"
+	}
+	return "Unknown"
+}
+
+// serviceRequest is a control request sent to the exporter.
+type serviceRequest int
+
+const (
+	requestInterrogate serviceRequest = iota
+	requestStop
+	requestShutdown
+)
+
+// serviceHost runs the exporter: the Windows service control manager, the
+// console, or a fake in tests.
+type serviceHost interface {
+	// Requests returns the control requests to handle.
+	Requests() <-chan serviceRequest
+	// SetState reports a change of state.
+	SetState(serviceState)
+}
+
+// runService runs run under host. The service is reported running once run
+// calls started, and stopping when host requests a stop, which cancels the
+// context of run. It is reported stopped once run returned, with its error.
+func runService(host serviceHost, run func(ctx context.Context, started func()) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	state := make(chan serviceState, 1)
+	done := make(chan error, 1)
+	host.SetState(stateStartPending)
+	go func() {
+		done <- run(ctx, func() { state <- stateRunning })
+	}()
+
+	current := stateStartPending
+	for {
+		select {
+		case s := <-state:
+			current = s
+			host.SetState(current)
+		case req := <-host.Requests():
+			switch req {
+			case requestInterrogate:
+				host.SetState(current)
+			case requestStop, requestShutdown:
+				if current != stateStopPending {
+					current = stateStopPending
+					host.SetState(current)
+					cancel()
+				}
+			}
+		case err := <-done:
+			if current != stateStopPending {
+				host.SetState(stateStopPending)
+			}
+			host.SetState(stateStopped)
+			return err
+		}
+	}
+}
+
+// consoleHost runs the exporter from a console, stopping on an interrupt or
+// SIGTERM.
+type consoleHost struct {
+	requests chan serviceRequest
+}
+
+func newConsoleHost() *consoleHost {
+	h := &consoleHost{requests: make(chan serviceRequest)}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for range signals {
+			h.requests <- requestStop
+		}
+	}()
+	return h
+}
+
+func (h *consoleHost) Requests() <-chan serviceRequest { return h.requests }
+
+func (h *consoleHost) SetState(s serviceState) {
+	if s == stateStopPending {
+		log.Info("Shutting down HyperV exporter")
+	}
+}
+
+// serve serves server on l until ctx is done, then shuts the server down,
+// waiting up to drainTimeout for the requests in flight to complete.
+func serve(ctx context.Context, server *http.Server, l net.Listener, drainTimeout time.Duration) error {
+	errc := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			errc <- server.ServeTLS(l, "", "")
+		} else {
+			errc <- server.Serve(l)
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err == context.DeadlineExceeded {
+		log.Warnf("Requests still in flight after %s, closing their connections", drainTimeout)
+		server.Close()
+	}
+	<-errc
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// isService reports whether the exporter was started by the service control
+// manager, which only exists on Windows.
+func isService() (bool, error) {
+	return false, nil
+}
+
+func runAsService(run func(ctx context.Context, started func()) error, stopTimeout time.Duration) error {
+	return errors.New("services are only supported on Windows")
+}
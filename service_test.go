@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHost records the reported states.
+type fakeHost struct {
+	requests chan serviceRequest
+
+	mtx    sync.Mutex
+	states []serviceState
+}
+
+func newFakeHost() *fakeHost {
+	return &fakeHost{requests: make(chan serviceRequest)}
+}
+
+func (h *fakeHost) Requests() <-chan serviceRequest { return h.requests }
+
+func (h *fakeHost) SetState(s serviceState) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.states = append(h.states, s)
+}
+
+func (h *fakeHost) reported() []serviceState {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return append([]serviceState(nil), h.states...)
+}
+
+func TestRunServiceStop(t *testing.T) {
+	h := newFakeHost()
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- runService(h, func(ctx context.Context, ready func()) error {
+			ready()
+			close(started)
+			<-ctx.Done()
+			// Draining.
+			<-stopped
+			return nil
+		})
+	}()
+
+	<-started
+	h.requests <- requestInterrogate
+	h.requests <- requestStop
+	// A second request while stopping is ignored.
+	h.requests <- requestShutdown
+	want := []serviceState{stateStartPending, stateRunning, stateRunning, stateStopPending}
+	if got := h.reported(); !reflect.DeepEqual(got, want) {
+		t.Errorf("before run returned: got %v, want %v", got, want)
+	}
+
+	close(stopped)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, stateStopped)
+	if got := h.reported(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRunServiceFailure(t *testing.T) {
+	h := newFakeHost()
+	errListen := errors.New("address already in use")
+	err := runService(h, func(ctx context.Context, ready func()) error {
+		return errListen
+	})
+	if err != errListen {
+		t.Errorf("got error %v, want %v", err, errListen)
+	}
+	want := []serviceState{stateStartPending, stateStopPending, stateStopped}
+	if got := h.reported(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestServeDrainsRequests(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		drainTimeout time.Duration
+		wantBody     bool
+	}{
+		{"in time", time.Minute, true},
+		{"too slow", 50 * time.Millisecond, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inFlight := make(chan struct{})
+			finish := make(chan struct{})
+			server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(inFlight)
+				select {
+				case <-finish:
+				case <-r.Context().Done():
+					return
+				}
+				w.Write([]byte("hyperV_health_critical 0\n"))
+			})}
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			served := make(chan error)
+			go func() { served <- serve(ctx, server, l, tc.drainTimeout) }()
+
+			body := make(chan string, 1)
+			go func() {
+				resp, err := http.Get("http://" + l.Addr().String())
+				if err != nil {
+					body <- ""
+					return
+				}
+				defer resp.Body.Close()
+				b, _ := ioutil.ReadAll(resp.Body)
+				body <- string(b)
+			}()
+
+			<-inFlight
+			cancel()
+			// New connections are refused once shutting down.
+			for i := 0; ; i++ {
+				c, err := net.Dial("tcp", l.Addr().String())
+				if err != nil {
+					break
+				}
+				c.Close()
+				if i == 100 {
+					t.Fatal("still accepting connections")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if tc.wantBody {
+				close(finish)
+			}
+
+			if err := <-served; err != nil {
+				t.Fatal(err)
+			}
+			if got := <-body; (got != "") != tc.wantBody {
+				t.Errorf("got body %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"golang.org/x/sys/windows/svc"
+)
+
+// isService reports whether the exporter was started by the service control
+// manager.
+func isService() (bool, error) {
+	interactive, err := svc.IsAnInteractiveSession()
+	return !interactive, err
+}
+
+// runAsService runs run under the service control manager until the service
+// is stopped.
+func runAsService(run func(ctx context.Context, started func()) error, stopTimeout time.Duration) error {
+	s := &windowsService{run: run, stopTimeout: stopTimeout}
+	if err := svc.Run(serviceName, s); err != nil {
+		return err
+	}
+	return s.err
+}
+
+// windowsService runs the exporter under the service control manager.
+type windowsService struct {
+	run func(ctx context.Context, started func()) error
+	// stopTimeout is how long stopping may take, reported to the service
+	// control manager as a wait hint.
+	stopTimeout time.Duration
+	// err is the error run returned.
+	err error
+}
+
+func (s *windowsService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
+	h := &scmHost{requests: make(chan serviceRequest), changes: changes, stopTimeout: s.stopTimeout}
+	stop := make(chan struct{})
+	defer close(stop)
+	go h.translate(r, stop)
+
+	s.err = runService(h, s.run)
+	if s.err != nil {
+		log.Errorf("HyperV exporter failed: %s", s.err)
+		return true, 1
+	}
+	return false, 0
+}
+
+// scmHost adapts the requests and states of the service control manager.
+type scmHost struct {
+	requests    chan serviceRequest
+	changes     chan<- svc.Status
+	stopTimeout time.Duration
+}
+
+// translate forwards the control requests of r until stop is closed.
+func (h *scmHost) translate(r <-chan svc.ChangeRequest, stop <-chan struct{}) {
+	for {
+		select {
+		case c := <-r:
+			var req serviceRequest
+			switch c.Cmd {
+			case svc.Interrogate:
+				req = requestInterrogate
+			case svc.Stop:
+				req = requestStop
+			case svc.Shutdown:
+				req = requestShutdown
+			default:
+				log.Errorf("unexpected control request #%d", c.Cmd)
+				continue
+			}
+			select {
+			case h.requests <- req:
+			case <-stop:
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (h *scmHost) Requests() <-chan serviceRequest { return h.requests }
+
+func (h *scmHost) SetState(s serviceState) {
+	switch s {
+	case stateStartPending:
+		h.changes <- svc.Status{State: svc.StartPending}
+	case stateRunning:
+		h.changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	case stateStopPending:
+		h.changes <- svc.Status{State: svc.StopPending, WaitHint: uint32(h.stopTimeout / time.Millisecond)}
+	case stateStopped:
+		// Reported by svc.Run once Execute returned, with its exit code.
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// collectorStatus is the outcome of the last runs of a collector, as reported
+// by /ready.
+type collectorStatus struct {
+	// Timestamp is the time of the last run.
+	Timestamp       time.Time  `json:"timestamp"`
+	DurationSeconds float64    `json:"duration_seconds"`
+	LastSuccess     *time.Time `json:"last_success,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+	// FailingSince is the time of the first failure since the last success.
+	FailingSince *time.Time `json:"failing_since,omitempty"`
+}
+
+// statusTracker records the status of the collectors across reloads.
+type statusTracker struct {
+	now func() time.Time
+
+	mtx      sync.Mutex
+	statuses map[string]collectorStatus
+}
+
+func newStatusTracker() *statusTracker {
+	return &statusTracker{now: time.Now, statuses: map[string]collectorStatus{}}
+}
+
+// record updates the status of the collector of r. It does nothing on a nil
+// tracker.
+func (t *statusTracker) record(r collectorResult) {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	now := t.now()
+	s := t.statuses[r.name]
+	s.Timestamp = now
+	s.DurationSeconds = r.duration.Seconds()
+	if r.err == nil {
+		s.LastSuccess = &now
+		s.LastError = ""
+		s.FailingSince = nil
+	} else {
+		s.LastError = r.err.Error()
+		if s.FailingSince == nil {
+			s.FailingSince = &now
+		}
+	}
+	t.statuses[r.name] = s
+}
+
+// readiness is the document served by /ready.
+type readiness struct {
+	Status     string                     `json:"status"`
+	Collectors map[string]collectorStatus `json:"collectors"`
+	// Failing lists the core collectors failing for longer than the
+	// threshold.
+	Failing []string `json:"failing,omitempty"`
+}
+
+// check returns the status of the enabled collectors. The exporter is not
+// ready when one of the core collectors has been failing for longer than
+// threshold; collectors that did not run yet do not count as failing.
+func (t *statusTracker) check(enabled, core []string, threshold time.Duration) readiness {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	r := readiness{Status: "ready", Collectors: map[string]collectorStatus{}}
+	for _, name := range enabled {
+		if s, ok := t.statuses[name]; ok {
+			r.Collectors[name] = s
+		}
+	}
+	now := t.now()
+	for _, name := range core {
+		if s := t.statuses[name]; s.FailingSince != nil && now.Sub(*s.FailingSince) > threshold {
+			r.Failing = append(r.Failing, name)
+		}
+	}
+	if len(r.Failing) > 0 {
+		r.Status = "not ready"
+	}
+	return r
+}
+
+// readyHandler serves the readiness of the collectors of the current
+// configuration, with a 503 status when not ready.
+func (t *statusTracker) readyHandler(config func() *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := config()
+		core := c.Collectors.Ready.Core
+		if len(core) == 0 {
+			core = c.Collectors.Enabled
+		}
+		ready := t.check(c.Collectors.Enabled, core, time.Duration(c.Collectors.Ready.FailureThreshold))
+
+		w.Header().Set("Content-Type", "application/json")
+		if len(ready.Failing) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(ready)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestReadyHandler(t *testing.T) {
+	now := time.Unix(1000, 0).UTC()
+	tracker := newStatusTracker()
+	tracker.now = func() time.Time { return now }
+
+	config := &Config{Collectors: CollectorsConfig{
+		Enabled: []string{"vid", "health", "custom"},
+		Ready: ReadyConfig{
+			Core:             []string{"vid", "health"},
+			FailureThreshold: model.Duration(time.Minute),
+		},
+	}}
+	handler := tracker.readyHandler(func() *Config { return config })
+	ready := func() (int, readiness) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		var r readiness
+		if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, r
+	}
+
+	// Nothing ran yet.
+	if code, r := ready(); code != http.StatusOK || r.Status != "ready" || len(r.Collectors) != 0 {
+		t.Errorf("before any collection: got %d %+v", code, r)
+	}
+
+	tracker.record(collectorResult{name: "vid", duration: time.Second})
+	tracker.record(collectorResult{name: "health", duration: time.Second})
+	tracker.record(collectorResult{name: "custom", err: errors.New("invalid class")})
+	if code, r := ready(); code != http.StatusOK || len(r.Collectors) != 3 {
+		t.Errorf("non-core collector failing: got %d %+v", code, r)
+	}
+
+	now = now.Add(time.Minute)
+	failedAt := now
+	tracker.record(collectorResult{name: "vid", duration: 2 * time.Second, err: errTimeout})
+	now = now.Add(time.Minute)
+	tracker.record(collectorResult{name: "vid", duration: 2 * time.Second, err: errTimeout})
+	if code, _ := ready(); code != http.StatusOK {
+		t.Errorf("failing for the threshold: got %d", code)
+	}
+
+	now = now.Add(time.Second)
+	code, r := ready()
+	if code != http.StatusServiceUnavailable || r.Status != "not ready" || !reflect.DeepEqual(r.Failing, []string{"vid"}) {
+		t.Errorf("failing for longer than the threshold: got %d %+v", code, r)
+	}
+	vid := r.Collectors["vid"]
+	if vid.LastError != errTimeout.Error() || vid.DurationSeconds != 2 ||
+		!vid.FailingSince.Equal(failedAt) || !vid.LastSuccess.Equal(failedAt.Add(-time.Minute)) {
+		t.Errorf("unexpected vid status %+v", vid)
+	}
+
+	tracker.record(collectorResult{name: "vid", duration: time.Second})
+	if code, r := ready(); code != http.StatusOK || r.Collectors["vid"].FailingSince != nil || r.Collectors["vid"].LastError != "" {
+		t.Errorf("after a success: got %d %+v", code, r)
+	}
+}
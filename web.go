@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// loadWebConfig reads a web configuration file in the format of the
+// Prometheus exporter toolkit.
+func loadWebConfig(path string) (HTTPSConfig, error) {
+	var config HTTPSConfig
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	err = yaml.UnmarshalStrict(b, &config)
+	return config, err
+}
+
+// authenticate wraps h and requires HTTP basic authentication when users are
+// configured.
+func authenticate(config func() *Config, h http.Handler) http.Handler {
+	cache := &authCache{valid: map[[sha256.Size]byte]bool{}}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		users := config().Web.BasicAuthUsers
+		if len(users) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		user, pass, ok := r.BasicAuth()
+		if ok {
+			if hash, found := users[user]; found {
+				if cache.check(user, hash, pass) {
+					h.ServeHTTP(w, r)
+					return
+				}
+			} else {
+				// Take as long as for a wrong password, so that the time
+				// taken does not reveal which users exist.
+				compareHashAndPassword([]byte(dummyHash), []byte(pass))
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", `Basic realm="hyperV_exporter"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+// dummyHash is the bcrypt hash checked for unknown users.
+const dummyHash = "$2a$10$VARU5UKV7UZI8I3UdO0ra.krA7q80xP5vvXsuhrin3EBGbNS0w/DO"
+
+// maxAuthCacheSize bounds the number of successful checks kept by authCache.
+const maxAuthCacheSize = 100
+
+// compareHashAndPassword is replaced in tests.
+var compareHashAndPassword = bcrypt.CompareHashAndPassword
+
+// authCache remembers the successful password checks, as bcrypt is made to
+// be slow and Prometheus sends the same credentials on every scrape. Entries
+// are keyed by a digest of the user, hash and password, so that changing the
+// hash of a user invalidates them.
+type authCache struct {
+	mtx   sync.Mutex
+	valid map[[sha256.Size]byte]bool
+}
+
+// check reports whether pass matches the bcrypt hash of user.
+func (c *authCache) check(user, hash, pass string) bool {
+	key := sha256.Sum256([]byte(user + "\x00" + hash + "\x00" + pass))
+	c.mtx.Lock()
+	valid := c.valid[key]
+	c.mtx.Unlock()
+	if valid {
+		return true
+	}
+
+	if compareHashAndPassword([]byte(hash), []byte(pass)) != nil {
+		return false
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if len(c.valid) >= maxAuthCacheSize {
+		c.valid = map[[sha256.Size]byte]bool{}
+	}
+	c.valid[key] = true
+	return true
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// newTLSConfig loads the files of config, or returns nil when TLS is not
+// configured.
+func newTLSConfig(config TLSServerConfig) (*tls.Config, error) {
+	if config.CertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	c := &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		MinVersion:               uint16(config.MinVersion),
+		MaxVersion:               uint16(config.MaxVersion),
+		PreferServerCipherSuites: config.PreferServerCipherSuites,
+	}
+	if c.MinVersion == 0 {
+		c.MinVersion = tls.VersionTLS12
+	}
+	for _, cs := range config.CipherSuites {
+		c.CipherSuites = append(c.CipherSuites, uint16(cs))
+	}
+
+	authType, ok := clientAuthTypes[config.ClientAuthType]
+	if !ok {
+		return nil, fmt.Errorf("invalid client_auth_type %q", config.ClientAuthType)
+	}
+	c.ClientAuth = authType
+	if config.ClientCAFile != "" {
+		b, err := ioutil.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.ClientCAs = x509.NewCertPool()
+		if !c.ClientCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate found in client_ca_file %s", config.ClientCAFile)
+		}
+	}
+	if (authType == tls.VerifyClientCertIfGiven || authType == tls.RequireAndVerifyClientCert) && c.ClientCAs == nil {
+		return nil, fmt.Errorf("client_auth_type %s needs a client_ca_file", config.ClientAuthType)
+	}
+	return c, nil
+}
+
+// reloadableTLSConfig serves the TLS configuration returned by current for
+// each connection, so that renewed certificates and new settings are picked
+// up without a restart.
+func reloadableTLSConfig(current func() *tls.Config) *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return current(), nil
+		},
+		// Lets the server start without a certificate in this config; it
+		// is never used as the one above takes precedence.
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &current().Certificates[0], nil
+		},
+	}
+}
+
+// tlsVersion is a TLS version, named like in the exporter toolkit.
+type tlsVersion uint16
+
+var tlsVersions = map[string]tlsVersion{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+func (v *tlsVersion) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	version, ok := tlsVersions[s]
+	if !ok {
+		return fmt.Errorf("unknown TLS version %q", s)
+	}
+	*v = version
+	return nil
+}
+
+// cipherSuite is a cipher suite, named like in crypto/tls. Only the secure
+// ones can be used.
+type cipherSuite uint16
+
+func (c *cipherSuite) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	for _, cs := range tls.CipherSuites() {
+		if cs.Name == s {
+			*c = cipherSuite(cs.ID)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown or insecure cipher suite %q", s)
+}
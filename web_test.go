@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoadWebConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "web.yml")
+
+	write := func(s string) {
+		if err := ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`
+tls_server_config:
+  cert_file: server.crt
+  key_file: server.key
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: ca.crt
+  min_version: TLS13
+  cipher_suites: [TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256]
+basic_auth_users:
+  prometheus: $2y$10$hash
+`)
+	config, err := loadConfig("", path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig := config.Web.TLSServerConfig
+	if tlsConfig.MinVersion != tls.VersionTLS13 || tlsConfig.ClientAuthType != "RequireAndVerifyClientCert" ||
+		len(tlsConfig.CipherSuites) != 1 || tlsConfig.CipherSuites[0] != cipherSuite(tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) {
+		t.Errorf("unexpected TLS config %+v", tlsConfig)
+	}
+	if config.Web.BasicAuthUsers["prometheus"] != "$2y$10$hash" {
+		t.Errorf("unexpected users %v", config.Web.BasicAuthUsers)
+	}
+
+	for _, invalid := range []string{
+		"tls_server_config: {min_version: SSL30}",
+		"tls_server_config: {cipher_suites: [TLS_RSA_WITH_RC4_128_SHA]}",
+		"tls_server_config: {cert_file: server.crt}",
+		"http_server_config: {}",
+	} {
+		write(invalid)
+		if _, err := loadConfig("", path, Config{}); err == nil {
+			t.Errorf("%q: expected an error", invalid)
+		}
+	}
+}
+
+// testCert returns a certificate signed by parent, or self-signed if parent
+// is nil, in PEM.
+func testCert(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestTLSClientVerification(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, b []byte) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	ca, caKey, caPEM, _ := testCert(t, "ca", nil, nil)
+	_, _, serverPEM, serverKeyPEM := testCert(t, "localhost", ca, caKey)
+	_, _, clientPEM, clientKeyPEM := testCert(t, "prometheus", ca, caKey)
+	_, _, otherPEM, otherKeyPEM := testCert(t, "other", nil, nil)
+
+	config := TLSServerConfig{
+		CertFile:       write("server.crt", serverPEM),
+		KeyFile:        write("server.key", serverKeyPEM),
+		ClientAuthType: "RequireAndVerifyClientCert",
+	}
+	if _, err := newTLSConfig(config); err == nil {
+		t.Fatal("expected an error without client_ca_file")
+	}
+	config.ClientCAFile = write("ca.crt", caPEM)
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = reloadableTLSConfig(func() *tls.Config { return tlsConfig })
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	get := func(certPEM, keyPEM []byte) error {
+		clientConfig := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+		if certPEM != nil {
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientConfig.Certificates = []tls.Certificate{cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if err := get(clientPEM, clientKeyPEM); err != nil {
+		t.Errorf("client with a certificate of the CA: %s", err)
+	}
+	if err := get(nil, nil); err == nil {
+		t.Error("client without a certificate: expected an error")
+	}
+	if err := get(otherPEM, otherKeyPEM); err == nil {
+		t.Error("client with a certificate of another CA: expected an error")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{}
+	handler := authenticate(func() *Config { return config }, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func(user, pass string) int {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if user != "" {
+			r.SetBasicAuth(user, pass)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	if code := get("", ""); code != http.StatusOK {
+		t.Errorf("without users: got %d", code)
+	}
+
+	config = &Config{Web: WebConfig{HTTPSConfig: HTTPSConfig{BasicAuthUsers: map[string]string{"prometheus": string(hash)}}}}
+	for _, tc := range []struct {
+		user, pass string
+		want       int
+	}{
+		{"prometheus", "secret", http.StatusOK},
+		{"prometheus", "wrong", http.StatusUnauthorized},
+		{"other", "secret", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	} {
+		if code := get(tc.user, tc.pass); code != tc.want {
+			t.Errorf("%s:%s: got %d, want %d", tc.user, tc.pass, code, tc.want)
+		}
+	}
+}
+
+func TestAuthenticateComparisons(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compared []string
+	defer func(f func([]byte, []byte) error) { compareHashAndPassword = f }(compareHashAndPassword)
+	compareHashAndPassword = func(hash, pass []byte) error {
+		compared = append(compared, string(hash))
+		return bcrypt.CompareHashAndPassword(hash, pass)
+	}
+
+	config := &Config{Web: WebConfig{HTTPSConfig: HTTPSConfig{BasicAuthUsers: map[string]string{"prometheus": string(hash)}}}}
+	handler := authenticate(func() *Config { return config }, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func(user, pass string) int {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.SetBasicAuth(user, pass)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	// Unknown users are checked against the dummy hash.
+	if code := get("other", "secret"); code != http.StatusUnauthorized {
+		t.Errorf("unknown user: got %d", code)
+	}
+	if len(compared) != 1 || compared[0] != dummyHash {
+		t.Errorf("unknown user: compared %v, want the dummy hash", compared)
+	}
+
+	// Successful checks are cached, failed ones are not.
+	compared = nil
+	for i := 0; i < 3; i++ {
+		if code := get("prometheus", "secret"); code != http.StatusOK {
+			t.Errorf("valid password: got %d", code)
+		}
+		if code := get("prometheus", "wrong"); code != http.StatusUnauthorized {
+			t.Errorf("wrong password: got %d", code)
+		}
+	}
+	if len(compared) != 4 {
+		t.Errorf("got %d comparisons, want 1 for the valid password and 3 for the wrong one", len(compared))
+	}
+
+	// A new hash for the user is checked again.
+	hash, err = bcrypt.GenerateFromPassword([]byte("changed"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = &Config{Web: WebConfig{HTTPSConfig: HTTPSConfig{BasicAuthUsers: map[string]string{"prometheus": string(hash)}}}}
+	if code := get("prometheus", "secret"); code != http.StatusUnauthorized {
+		t.Errorf("old password after a change: got %d", code)
+	}
+}
@@ -0,0 +1,202 @@
+// Package wsman implements a minimal WS-Management client running WQL queries
+// against the WinRM service of Windows hosts, for networks where DCOM is not
+// allowed. Only basic authentication is supported, so HTTPS should be used
+// unless the host explicitly allows unencrypted traffic.
+package wsman
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPort and DefaultHTTPSPort are the default ports of WinRM.
+	DefaultPort      = 5985
+	DefaultHTTPSPort = 5986
+
+	resourceURIPrefix = "http://schemas.microsoft.com/wbem/wsman/1/wmi/"
+	wqlDialect        = "http://schemas.microsoft.com/wbem/wsman/1/WQL"
+
+	actionEnumerate = "http://schemas.xmlsoap.org/ws/2004/09/enumeration/Enumerate"
+	actionPull      = "http://schemas.xmlsoap.org/ws/2004/09/enumeration/Pull"
+
+	// maxElements is the number of instances requested per round trip.
+	maxElements = 512
+)
+
+// Client runs WQL queries with WS-Management enumerations. It implements the
+// Querier interface of the collectors.
+type Client struct {
+	// Endpoint is the URL of the WinRM listener, e.g.
+	// https://host:5986/wsman.
+	Endpoint string
+	Username string
+	Password string
+	// HTTPClient defaults to a client with a one minute timeout.
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client for the WinRM listener of host. Ports are
+// defaulted by scheme when host does not have one.
+func NewClient(host string, https bool, insecureSkipVerify bool, username, password string) *Client {
+	scheme, port := "http", DefaultPort
+	if https {
+		scheme, port = "https", DefaultHTTPSPort
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(port))
+	}
+	return &Client{
+		Endpoint: fmt.Sprintf("%s://%s/wsman", scheme, host),
+		Username: username,
+		Password: password,
+		HTTPClient: &http.Client{
+			Timeout:   time.Minute,
+			Transport: transport(insecureSkipVerify),
+		},
+	}
+}
+
+var (
+	transportsMtx sync.Mutex
+	// transports are shared by the clients, so that the connections kept
+	// alive are reused across clients instead of piling up, one per client.
+	transports = map[bool]*http.Transport{}
+)
+
+// transport returns the shared transport verifying, or not, the certificates
+// of the hosts.
+func transport(insecureSkipVerify bool) *http.Transport {
+	transportsMtx.Lock()
+	defer transportsMtx.Unlock()
+	t, ok := transports[insecureSkipVerify]
+	if !ok {
+		t = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: insecureSkipVerify},
+			IdleConnTimeout: 90 * time.Second,
+		}
+		transports[insecureSkipVerify] = t
+	}
+	return t
+}
+
+// Query runs a WQL query in namespace, root\cimv2 if empty, and stores the
+// returned instances in dst, a pointer to a slice of structs, like wmi.Query.
+func (c *Client) Query(query string, dst interface{}, namespace string) error {
+	if namespace == "" {
+		namespace = `root\cimv2`
+	}
+	resourceURI := resourceURIPrefix + strings.ToLower(strings.Replace(namespace, `\`, "/", -1)) + "/*"
+
+	rows, err := newRows(dst)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(`<wsen:Enumerate><wsman:OptimizeEnumeration/><wsman:MaxElements>%d</wsman:MaxElements><wsman:Filter Dialect="%s">%s</wsman:Filter></wsen:Enumerate>`,
+		maxElements, wqlDialect, escape(query))
+	resp, err := c.call(actionEnumerate, resourceURI, body)
+	if err != nil {
+		return err
+	}
+	result := resp.Body.EnumerateResponse
+	if result == nil {
+		return fmt.Errorf("wsman: no EnumerateResponse in the reply to %q", query)
+	}
+
+	for {
+		if err := rows.append(result.Items.Instances); err != nil {
+			return err
+		}
+		if result.EndOfSequence != nil || result.EnumerationContext == "" {
+			return nil
+		}
+
+		body := fmt.Sprintf(`<wsen:Pull><wsen:EnumerationContext>%s</wsen:EnumerationContext><wsen:MaxElements>%d</wsen:MaxElements></wsen:Pull>`,
+			escape(result.EnumerationContext), maxElements)
+		resp, err := c.call(actionPull, resourceURI, body)
+		if err != nil {
+			return err
+		}
+		if result = resp.Body.PullResponse; result == nil {
+			return fmt.Errorf("wsman: no PullResponse in the reply to %q", query)
+		}
+	}
+}
+
+// call sends a SOAP request and decodes the reply, turning faults into
+// errors.
+func (c *Client) call(action, resourceURI, body string) (*envelope, error) {
+	var req bytes.Buffer
+	fmt.Fprintf(&req, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:wsen="http://schemas.xmlsoap.org/ws/2004/09/enumeration" xmlns:wsman="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd">`)
+	fmt.Fprintf(&req, `<s:Header><a:To>%s</a:To><wsman:ResourceURI s:mustUnderstand="true">%s</wsman:ResourceURI>`, escape(c.Endpoint), escape(resourceURI))
+	fmt.Fprintf(&req, `<a:ReplyTo><a:Address s:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>`)
+	fmt.Fprintf(&req, `<a:Action s:mustUnderstand="true">%s</a:Action><a:MessageID>uuid:%s</a:MessageID>`, action, newUUID())
+	fmt.Fprintf(&req, `<wsman:MaxEnvelopeSize s:mustUnderstand="true">512000</wsman:MaxEnvelopeSize><wsman:OperationTimeout>PT60S</wsman:OperationTimeout></s:Header>`)
+	fmt.Fprintf(&req, `<s:Body>%s</s:Body></s:Envelope>`, body)
+
+	httpReq, err := http.NewRequest(http.MethodPost, c.Endpoint, &req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	if c.Username != "" {
+		httpReq.SetBasicAuth(c.Username, c.Password)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: time.Minute}
+	}
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	b, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp envelope
+	// Faults come with a 500 status; any other reply is not SOAP.
+	if xmlErr := xml.Unmarshal(b, &resp); xmlErr != nil {
+		if httpResp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("wsman: server returned HTTP status %s", httpResp.Status)
+		}
+		return nil, fmt.Errorf("wsman: invalid reply: %s", xmlErr)
+	}
+	if f := resp.Body.Fault; f != nil {
+		return nil, f
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("wsman: server returned HTTP status %s", httpResp.Status)
+	}
+	return &resp, nil
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// newUUID returns a random (version 4) UUID, used as message ID.
+func newUUID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
@@ -0,0 +1,204 @@
+package wsman
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/iyacontrol/HyperV-exporter/collector"
+)
+
+// request is the part of the requests checked by the fixture server.
+type request struct {
+	Header struct {
+		Action      string `xml:"Action"`
+		ResourceURI string `xml:"ResourceURI"`
+	} `xml:"Header"`
+	Body struct {
+		Filter  string `xml:"Enumerate>Filter"`
+		Context string `xml:"Pull>EnumerationContext"`
+	} `xml:"Body"`
+}
+
+// fixtureServer replies to the requests of the client with the files of
+// testdata, recording the requests it gets.
+func fixtureServer(t *testing.T, requests *[]request, replies ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "monitoring" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req request
+		if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid request: %s", err)
+		}
+		*requests = append(*requests, req)
+
+		if len(*requests) > len(replies) {
+			t.Errorf("unexpected request %+v", req)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		b, err := ioutil.ReadFile(filepath.Join("testdata", replies[len(*requests)-1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Header().Set("Content-Type", "application/soap+xml;charset=UTF-8")
+		if strings.HasPrefix(replies[len(*requests)-1], "fault") {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write(b)
+	}))
+}
+
+func TestQuery(t *testing.T) {
+	var requests []request
+	server := fixtureServer(t, &requests, "enumerate.xml", "pull.xml")
+	defer server.Close()
+
+	c := &Client{Endpoint: server.URL + "/wsman", Username: "monitoring", Password: "secret"}
+	var dst []collector.Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition
+	query := "SELECT Name, PhysicalPagesAllocated FROM Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition WHERE Name <> '_Total'"
+	if err := c.Query(query, &dst, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []collector.Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition{
+		{Name: "web01", PhysicalPagesAllocated: 1048576, RemotePhysicalPages: 12},
+		{Name: "db01", PhysicalPagesAllocated: 524288, PreferredNUMANodeIndex: 1},
+	}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("got %+v, want %+v", dst, want)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(requests))
+	}
+	if requests[0].Header.Action != actionEnumerate || requests[1].Header.Action != actionPull {
+		t.Errorf("got actions %q and %q", requests[0].Header.Action, requests[1].Header.Action)
+	}
+	if uri := requests[0].Header.ResourceURI; uri != "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/*" {
+		t.Errorf("got resource URI %q", uri)
+	}
+	if requests[0].Body.Filter != query {
+		t.Errorf("got filter %q, want %q", requests[0].Body.Filter, query)
+	}
+	if ctx := requests[1].Body.Context; ctx != "uuid:2B6C6D3E-1B5F-4A3B-9B8C-6E2C3F1A0D42" {
+		t.Errorf("pulled with context %q", ctx)
+	}
+}
+
+func TestQueryNamespace(t *testing.T) {
+	var requests []request
+	server := fixtureServer(t, &requests, "pull.xml")
+	defer server.Close()
+
+	c := &Client{Endpoint: server.URL + "/wsman", Username: "monitoring", Password: "secret"}
+	var dst []collector.Msvm_VirtualSystemSettingData
+	// pull.xml has no EnumerateResponse.
+	if err := c.Query("SELECT ElementName, Notes FROM Msvm_VirtualSystemSettingData", &dst, `root\virtualization\v2`); err == nil {
+		t.Error("expected an error")
+	}
+	if uri := requests[0].Header.ResourceURI; uri != "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/virtualization/v2/*" {
+		t.Errorf("got resource URI %q", uri)
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	var requests []request
+	server := fixtureServer(t, &requests, "fault.xml")
+	defer server.Close()
+
+	var dst []collector.Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition
+	c := &Client{Endpoint: server.URL + "/wsman", Username: "monitoring", Password: "secret"}
+	err := c.Query("SELECT Name FROM Win32_Missing", &dst, "")
+	if _, ok := err.(*Fault); !ok {
+		t.Fatalf("expected a fault, got %v", err)
+	}
+	if want := "wsman: The parameter is incorrect.: Invalid class Win32_Missing (w:InvalidParameter)"; err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+
+	c.Password = "wrong"
+	if err := c.Query("SELECT Name FROM Win32_Missing", &dst, ""); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected an HTTP 401 error, got %v", err)
+	}
+
+	if err := c.Query("SELECT Name FROM Win32_Missing", dst, ""); err == nil {
+		t.Error("expected an error for a dst that is not a pointer")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	type row struct {
+		Name    string
+		Notes   []string
+		Enabled bool
+		Count   uint32
+		Delta   int64
+		Ratio   float64
+		private string
+	}
+	instances := []instance{{Properties: []property{
+		{XMLName: xml.Name{Local: "Name"}, Value: "web01"},
+		{XMLName: xml.Name{Local: "Notes"}, Value: "team=payments"},
+		{XMLName: xml.Name{Local: "Notes"}, Value: "owner=alice"},
+		{XMLName: xml.Name{Local: "Enabled"}, Value: "true"},
+		{XMLName: xml.Name{Local: "Count"}, Value: "42"},
+		{XMLName: xml.Name{Local: "Delta"}, Value: "-3"},
+		{XMLName: xml.Name{Local: "Ratio"}, Nil: true},
+		{XMLName: xml.Name{Local: "private"}, Value: "ignored"},
+		{XMLName: xml.Name{Local: "Unknown"}, Value: "ignored"},
+	}}}
+
+	var dst []row
+	r, err := newRows(&dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.append(instances); err != nil {
+		t.Fatal(err)
+	}
+	want := []row{{Name: "web01", Notes: []string{"team=payments", "owner=alice"}, Enabled: true, Count: 42, Delta: -3}}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("got %+v, want %+v", dst, want)
+	}
+
+	overflow := []instance{{Properties: []property{{XMLName: xml.Name{Local: "Count"}, Value: "4294967296"}}}}
+	if err := r.append(overflow); err == nil {
+		t.Error("expected an error for an out of range value")
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	for _, test := range []struct {
+		host  string
+		https bool
+		want  string
+	}{
+		{"hv01", false, "http://hv01:5985/wsman"},
+		{"hv01", true, "https://hv01:5986/wsman"},
+		{"hv01:8080", false, "http://hv01:8080/wsman"},
+		{"fe80::1", true, "https://[fe80::1]:5986/wsman"},
+		{"[fe80::1]:5000", true, "https://[fe80::1]:5000/wsman"},
+	} {
+		if got := NewClient(test.host, test.https, false, "", "").Endpoint; got != test.want {
+			t.Errorf("%s: got %s, want %s", test.host, got, test.want)
+		}
+	}
+}
+
+func TestClientsShareTransports(t *testing.T) {
+	a := NewClient("hv01", true, false, "", "").HTTPClient.Transport
+	if b := NewClient("hv02", true, false, "", "").HTTPClient.Transport; a != b {
+		t.Error("clients should share their transport")
+	}
+	if b := NewClient("hv01", true, true, "", "").HTTPClient.Transport; a == b {
+		t.Error("clients skipping the verification should not share the transport of those verifying")
+	}
+}
@@ -0,0 +1,153 @@
+package wsman
+
+import (
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envelope is the part of the SOAP replies used by the client. Elements are
+// matched by local name, as the namespaces of the items depend on the class.
+type envelope struct {
+	Body struct {
+		Fault             *Fault               `xml:"Fault"`
+		EnumerateResponse *enumerationResponse `xml:"EnumerateResponse"`
+		PullResponse      *enumerationResponse `xml:"PullResponse"`
+	} `xml:"Body"`
+}
+
+type enumerationResponse struct {
+	EnumerationContext string    `xml:"EnumerationContext"`
+	Items              items     `xml:"Items"`
+	EndOfSequence      *struct{} `xml:"EndOfSequence"`
+}
+
+type items struct {
+	Instances []instance `xml:",any"`
+}
+
+// instance is a CIM instance, with one element per property value. Array
+// properties repeat the element.
+type instance struct {
+	XMLName    xml.Name
+	Properties []property `xml:",any"`
+}
+
+type property struct {
+	XMLName xml.Name
+	Nil     bool   `xml:"http://www.w3.org/2001/XMLSchema-instance nil,attr"`
+	Value   string `xml:",chardata"`
+}
+
+// Fault is a SOAP fault returned by the server.
+type Fault struct {
+	Code   string `xml:"Code>Subcode>Value"`
+	Reason string `xml:"Reason>Text"`
+	// Message is the WMI error, when there is one.
+	Message string `xml:"Detail>WSManFault>Message"`
+}
+
+func (f *Fault) Error() string {
+	msg := strings.TrimSpace(f.Reason)
+	if m := strings.TrimSpace(f.Message); m != "" && m != msg {
+		msg += ": " + m
+	}
+	return fmt.Sprintf("wsman: %s (%s)", msg, f.Code)
+}
+
+// rows appends instances to the slice a query result is stored in.
+type rows struct {
+	slice reflect.Value
+	elem  reflect.Type
+}
+
+func newRows(dst interface{}) (*rows, error) {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice || v.Elem().Type().Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("wsman: dst must be a pointer to a slice of structs, got %T", dst)
+	}
+	slice := v.Elem()
+	slice.Set(slice.Slice(0, 0))
+	return &rows{slice: slice, elem: slice.Type().Elem()}, nil
+}
+
+// append decodes instances into new elements. Exported fields are filled from
+// the properties of the same name; properties without a field are ignored and
+// fields without a property, or with a nil one, are left zero.
+func (r *rows) append(instances []instance) error {
+	for _, inst := range instances {
+		values := map[string][]string{}
+		for _, p := range inst.Properties {
+			if !p.Nil {
+				values[p.XMLName.Local] = append(values[p.XMLName.Local], p.Value)
+			}
+		}
+
+		row := reflect.New(r.elem).Elem()
+		for i := 0; i < r.elem.NumField(); i++ {
+			f := r.elem.Field(i)
+			v, ok := values[f.Name]
+			if !ok || f.PkgPath != "" {
+				continue
+			}
+			if err := setField(row.Field(i), v); err != nil {
+				return fmt.Errorf("wsman: %s.%s: %s", inst.XMLName.Local, f.Name, err)
+			}
+		}
+		r.slice.Set(reflect.Append(r.slice, row))
+	}
+	return nil
+}
+
+func setField(f reflect.Value, values []string) error {
+	if f.Kind() == reflect.Slice {
+		s := reflect.MakeSlice(f.Type(), len(values), len(values))
+		for i, v := range values {
+			if err := setScalar(s.Index(i), v); err != nil {
+				return err
+			}
+		}
+		f.Set(s)
+		return nil
+	}
+	if len(values) != 1 {
+		return fmt.Errorf("expected a single value, got %d", len(values))
+	}
+	return setScalar(f, values[0])
+}
+
+func setScalar(f reflect.Value, s string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(strings.TrimSpace(s), 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported field type %s", f.Type())
+	}
+	return nil
+}